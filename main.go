@@ -5,25 +5,62 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"go-install-kubernetes/pkg/cli"
+	"go-install-kubernetes/pkg/cluster"
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/distro"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
 	"go-install-kubernetes/pkg/install"
+	"go-install-kubernetes/pkg/joinserver"
 )
 
 //go:embed manifests/*
 //go:embed manifests/calico/*
+//go:embed manifests/flannel/*
+//go:embed manifests/cilium/*
 var manifestFiles embed.FS
 
 func main() {
-	config := cli.ParseFlags(manifestFiles)
+	cfg := cli.ParseFlags(manifestFiles)
 
 	// Allow -h without root check
 	if len(os.Args) == 2 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
 		return
 	}
 
+	// The cluster command only drives other hosts, so it needs no root
+	if cfg.Command == config.CommandCluster {
+		if err := cluster.Apply(cfg, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Commands still run on this host, so only a plan can target another root
+	if cfg.Root != "" && cfg.Root != "/" && !cfg.DryRun {
+		log.Fatal("--root only works with --dry-run")
+	}
+	if cfg.OS != "" {
+		if _, err := distro.Named(cfg.OS); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Print the plan without touching the host, so no root needed
+	if cfg.DryRun {
+		runner := exec.NewDryRunner(os.Stdout)
+		fsys := hostfs.NewDryRun(hostfs.New(cfg.Root), os.Stdout)
+		if err := run(cfg, runner, fsys); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Check if running as root
 	if os.Geteuid() != 0 {
 		log.Fatal("This script must be run as root")
@@ -34,45 +71,109 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	config.LogFile = filepath.Join(tmpDir, "install.log")
+	cfg.LogFile = filepath.Join(tmpDir, "install.log")
 
 	// Create the log file
-	if _, err := os.Create(config.LogFile); err != nil {
+	if _, err := os.Create(cfg.LogFile); err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("Writing all output to: %s\n", config.LogFile)
+	fmt.Printf("Writing all output to: %s\n", cfg.LogFile)
 
 	defer os.RemoveAll(tmpDir)
 
-	if err := install.Kubernetes(config, manifestFiles); err != nil {
+	runner := exec.NewRunner(cfg)
+
+	if err := run(cfg, runner, hostfs.New(cfg.Root)); err != nil {
 		// Print log file on error
 		fmt.Println("\n### Error Log ###")
-		content, _ := os.ReadFile(config.LogFile)
+		content, _ := os.ReadFile(cfg.LogFile)
 		fmt.Println(string(content))
+		if cfg.Command == config.CommandInstall {
+			fmt.Println("Fix the problem and rerun with --resume to continue from the failed step")
+		}
 		log.Fatal(err)
 	}
 
 	// Print log file if verbose
-	if config.IsVerbose {
+	if cfg.IsVerbose {
 		fmt.Println("\n### Log file ###")
-		content, _ := os.ReadFile(config.LogFile)
+		content, _ := os.ReadFile(cfg.LogFile)
 		fmt.Println(string(content))
 	}
 
+	if cfg.Command == config.CommandInstall {
+		printJoinInstructions(cfg, runner)
+	}
+}
+
+// run carries out the command selected on the command line.
+func run(cfg *config.Config, runner exec.Runner, fsys hostfs.FS) error {
+	switch cfg.Command {
+	case config.CommandReset:
+		return install.Reset(cfg, runner, fsys)
+	case config.CommandUpgrade:
+		return install.Upgrade(cfg, runner, fsys)
+	case config.CommandServeJoin:
+		return joinserver.Serve(cfg, runner)
+	case config.CommandToken:
+		return install.Token(cfg, runner, fsys)
+	case config.CommandAddons:
+		return install.Addons(cfg, runner, fsys, manifestFiles)
+	case config.CommandBundle:
+		return install.Bundle(cfg, runner, fsys, manifestFiles)
+	default:
+		return install.Kubernetes(cfg, runner, fsys, manifestFiles)
+	}
+}
+
+func printJoinInstructions(cfg *config.Config, runner exec.Runner) {
+	if cfg.JoinControlPlane {
+		fmt.Printf("\n### This node has joined the control plane at %s ###\n", cfg.JoinEndpoint)
+		return
+	}
+
 	// Print join command for control plane
-	if config.IsControlNode || config.IsSingleNode {
-		fmt.Println("\n### Command to add a worker node ###")
-		output, err := exec.Command("kubeadm", "token", "create", "--print-join-command", "--ttl", "0").Output()
+	if cfg.IsControlNode || cfg.IsSingleNode {
+		if cfg.CNI == config.CNINone {
+			fmt.Println("\n### No CNI was installed, the nodes stay NotReady until a pod network is applied ###")
+		}
+		validity := "valid for " + cfg.JoinTokenTTL
+		if ttl, _ := time.ParseDuration(cfg.JoinTokenTTL); ttl == 0 {
+			validity = "never expires"
+		}
+		fmt.Printf("\n### Command to add a worker node (%s) ###\n", validity)
+		res, err := runner.Run(fmt.Sprintf("kubeadm token create --print-join-command --ttl %s", cfg.JoinTokenTTL))
 		if err != nil {
 			log.Printf("Failed to create join token: %v", err)
 		} else {
-			fmt.Println(string(output))
+			fmt.Println(res.Stdout)
+			if cfg.HighlyAvailable() {
+				printControlPlaneJoin(cfg, runner, strings.TrimSpace(res.Stdout))
+			}
 		}
+	} else if cfg.JoinEndpoint != "" {
+		fmt.Printf("\n### This node has joined the cluster at %s ###\n", cfg.JoinEndpoint)
 	} else {
 		fmt.Println("\n### To add this node as a worker node ###")
 		fmt.Println("Run the below on the control plane node:")
-		fmt.Println("kubeadm token create --print-join-command --ttl 0")
+		fmt.Printf("kubeadm token create --print-join-command --ttl %s\n", cfg.JoinTokenTTL)
 		fmt.Println("and execute the output on the worker nodes")
 	}
 }
+
+// printControlPlaneJoin prints the command for adding control plane nodes to
+// an HA cluster. The key is lost on --resume, so upload the certs again then.
+func printControlPlaneJoin(cfg *config.Config, runner exec.Runner, joinCmd string) {
+	key := cfg.CertificateKey
+	if key == "" {
+		var err error
+		if key, err = joinserver.UploadCerts(runner); err != nil {
+			log.Printf("Failed to upload certificates: %v", err)
+			return
+		}
+	}
+	fmt.Println("### Command to add a control plane node (certificate key valid for 2 hours) ###")
+	fmt.Printf("%s --control-plane --certificate-key %s\n", joinCmd, key)
+	fmt.Println("\nAfter two hours get a new key with: go-install-kubernetes token upload-certs")
+}
@@ -5,27 +5,95 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/install"
 )
 
 func ParseFlags(manifestFiles fs.FS) *config.Config {
-	cfg := &config.Config{
-		IsWorkerNode: false,
+	cfg := config.New()
+
+	// Anything other than a flag first is a subcommand
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		parseCommand(cfg, os.Args[1], os.Args[2:])
+		return cfg
 	}
 
 	flag.BoolVar(&cfg.IsControlNode, "c", false, "Configure as a control plane node")
 	flag.BoolVar(&cfg.IsWorkerNode, "w", false, "Configure as a worker node")
 	flag.BoolVar(&cfg.IsSingleNode, "s", false, "Configure as a single node (control plane + worker)")
 	flag.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
-	exportManifests := flag.Bool("export-manifests", false, "Export embedded Calico manifests to disk")
+	flag.BoolVar(&cfg.Resume, "resume", false, "Skip steps completed by a previous run and continue from the failure")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Print the commands and file writes without making them")
+	flag.StringVar(&cfg.Root, "root", "", "Write host files under this directory instead of /")
+	flag.StringVar(&cfg.OS, "os", "", "Release to plan for, such as ubuntu-24.04, instead of reading os-release")
+	exportManifests := flag.Bool("export-manifests", false, "Export embedded manifests to disk")
 	showVersion := flag.Bool("version", false, "Show version information")
+	configFile := flag.String("config", "", "Read settings from a YAML or JSON file")
+	podSubnet := flag.String("pod-subnet", config.DefaultPodSubnet, "Pod network CIDR")
+	serviceSubnet := flag.String("service-subnet", config.DefaultServiceSubnet, "Service network CIDR")
+	clusterDomain := flag.String("cluster-domain", config.DefaultClusterDomain, "Cluster DNS domain")
+	joinTokenTTL := flag.String("join-token-ttl", config.DefaultJoinTokenTTL, "Lifetime of the join token printed after install")
+	flag.StringVar(&cfg.JoinEndpoint, "join-endpoint", "", "Join the cluster at this host:port after installing a worker")
+	flag.StringVar(&cfg.JoinToken, "token", "", "Bootstrap token for --join-endpoint")
+	flag.StringVar(&cfg.JoinCAHash, "discovery-token-ca-cert-hash", "", "CA cert hash for --join-endpoint, as sha256:<hex>")
+	flag.StringVar(&cfg.JoinServer, "join-server", "", "Fetch join details from a serve-join server at this https:// URL")
+	flag.StringVar(&cfg.JoinPSK, "psk", os.Getenv("GIK_JOIN_PSK"), "Pre-shared key for --join-server")
+	joinCommandFile := flag.String("join-command-file", "", "Read the join settings from a saved kubeadm join command")
+	controlPlaneEndpoint := flag.String("control-plane-endpoint", "", "Shared DNS name or virtual IP for an HA control plane")
+	kubeVIP := flag.String("kube-vip", "", "Announce this virtual IP with kube-vip and use it as the control plane endpoint")
+	addons := flag.String("addons", strings.Join(config.DefaultAddons, ","), "Comma separated addons to install")
+	noAddons := flag.Bool("no-addons", false, "Install no addons")
+	localPathDir := flag.String("local-path-dir", config.DefaultLocalPathDir, "Directory on each node for local-path-provisioner volumes")
+	cni := flag.String("cni", config.DefaultCNI, "Pod network to install: calico, flannel, cilium or none")
+	kubeVIPInterface := flag.String("kube-vip-interface", "", "Interface to announce the kube-vip address on (default: the default route's)")
+	flag.BoolVar(&cfg.JoinControlPlane, "join-control-plane", false, "Join the cluster as an additional control plane node")
+	flag.StringVar(&cfg.CertificateKey, "certificate-key", os.Getenv("GIK_CERTIFICATE_KEY"), "Key for the uploaded certificates, for --join-control-plane")
+	selinuxPermissive := flag.Bool("selinux-permissive", false, "Switch an enforcing SELinux to permissive mode, as kubeadm requires")
+	flag.StringVar(&cfg.BundlePath, "bundle", "", "Install without network access from a bundle made by bundle create")
 
 	flag.Usage = showHelp
 	flag.Parse()
 
+	loadSettings(cfg, *configFile)
+
+	// Flags given on the command line win over the file and environment
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pod-subnet":
+			cfg.PodSubnet = *podSubnet
+		case "service-subnet":
+			cfg.ServiceSubnet = *serviceSubnet
+		case "cluster-domain":
+			cfg.ClusterDomain = *clusterDomain
+		case "join-token-ttl":
+			cfg.JoinTokenTTL = *joinTokenTTL
+		case "control-plane-endpoint":
+			cfg.ControlPlaneEndpoint = *controlPlaneEndpoint
+		case "kube-vip":
+			cfg.KubeVIP = *kubeVIP
+		case "kube-vip-interface":
+			cfg.KubeVIPInterface = *kubeVIPInterface
+		case "cni":
+			cfg.CNI = *cni
+		case "addons":
+			cfg.Addons = config.SplitList(*addons)
+		case "local-path-dir":
+			cfg.LocalPathDir = *localPathDir
+		case "selinux-permissive":
+			cfg.SELinuxPermissive = *selinuxPermissive
+		}
+	})
+	if *noAddons {
+		cfg.Addons = nil
+	}
+
+	validateSettings(cfg)
+
 	if *showVersion {
-		printVersion()
+		printVersion(cfg)
 		os.Exit(0)
 	}
 
@@ -37,6 +105,14 @@ func ParseFlags(manifestFiles fs.FS) *config.Config {
 		os.Exit(0)
 	}
 
+	if cfg.JoinControlPlane {
+		if cfg.IsWorkerNode || cfg.IsSingleNode {
+			fmt.Fprintln(os.Stderr, "Error: --join-control-plane can't be combined with -w or -s")
+			os.Exit(1)
+		}
+		cfg.IsControlNode = true
+	}
+
 	if !cfg.IsControlNode && !cfg.IsWorkerNode && !cfg.IsSingleNode {
 		showHelp()
 		os.Exit(0)
@@ -50,7 +126,60 @@ func ParseFlags(manifestFiles fs.FS) *config.Config {
 		cfg.IsWorkerNode = true
 	}
 
+	if *joinCommandFile != "" {
+		if err := config.LoadJoinCommand(*joinCommandFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.ValidateJoin(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.BundlePath != "" {
+		if cfg.ContainerdSource == config.ContainerdSourceRelease {
+			fmt.Fprintf(os.Stderr, "Error: containerdSource %s downloads from GitHub, which --bundle can't provide\n", config.ContainerdSourceRelease)
+			os.Exit(1)
+		}
+		path, err := bundlePath(cfg.BundlePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.BundlePath = path
+	}
+
 	return cfg
 }
 
-// ... (move all CLI-related functions here)
+// bundlePath returns the absolute path of an existing bundle, which is
+// unpacked from another directory.
+func bundlePath(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("bundle not found: %v", err)
+	}
+	return filepath.Abs(path)
+}
+
+// loadSettings applies the config file, if any, and then the environment.
+func loadSettings(cfg *config.Config, configFile string) {
+	if configFile != "" {
+		if err := config.Load(configFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	config.ApplyEnv(cfg)
+}
+
+func validateSettings(cfg *config.Config) {
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := install.ValidateAddons(cfg.Addons); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in config: %v\n", err)
+		os.Exit(1)
+	}
+}
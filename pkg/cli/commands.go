@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/install"
+)
+
+// parseCommand parses the flags for a subcommand. Each subcommand has its
+// own flag set so that install-only flags aren't accepted where they mean
+// nothing.
+func parseCommand(cfg *config.Config, name string, args []string) {
+	switch name {
+	case config.CommandReset:
+		parseReset(cfg, args)
+	case config.CommandUpgrade:
+		parseUpgrade(cfg, args)
+	case config.CommandServeJoin:
+		parseServeJoin(cfg, args)
+	case config.CommandToken:
+		parseToken(cfg, args)
+	case config.CommandCluster:
+		parseCluster(cfg, args)
+	case config.CommandAddons:
+		parseAddons(cfg, args)
+	case config.CommandBundle:
+		parseBundle(cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		showHelp()
+		os.Exit(1)
+	}
+}
+
+func parseReset(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandReset
+
+	flags := flag.NewFlagSet(config.CommandReset, flag.ExitOnError)
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	flags.BoolVar(&cfg.KeepPackages, "keep-packages", false, "Leave the Kubernetes and containerd packages installed")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the commands and file removals without making them")
+	flags.StringVar(&cfg.Root, "root", "", "Remove host files under this directory instead of /")
+	flags.StringVar(&cfg.OS, "os", "", "Release to plan for, such as ubuntu-24.04, instead of reading os-release")
+	flags.Usage = showResetHelp
+	flags.Parse(args)
+}
+
+func parseUpgrade(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandUpgrade
+
+	flags := flag.NewFlagSet(config.CommandUpgrade, flag.ExitOnError)
+	flags.StringVar(&cfg.UpgradeTo, "to", "", "Kubernetes version to upgrade to, e.g. 1.32 or 1.32.3")
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the commands and file writes without making them")
+	flags.StringVar(&cfg.Root, "root", "", "Write host files under this directory instead of /")
+	flags.StringVar(&cfg.OS, "os", "", "Release to plan for, such as ubuntu-24.04, instead of reading os-release")
+	configFile := flags.String("config", "", "Read settings from a YAML or JSON file")
+	flags.Usage = showUpgradeHelp
+	flags.Parse(args)
+
+	loadSettings(cfg, *configFile)
+	validateSettings(cfg)
+
+	if cfg.UpgradeTo == "" {
+		fmt.Fprintln(os.Stderr, "Error: --to is required")
+		showUpgradeHelp()
+		os.Exit(1)
+	}
+}
+
+func parseServeJoin(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandServeJoin
+
+	flags := flag.NewFlagSet(config.CommandServeJoin, flag.ExitOnError)
+	flags.StringVar(&cfg.ServeListen, "listen", ":9443", "Address to listen on")
+	flags.StringVar(&cfg.JoinPSK, "psk", os.Getenv("GIK_JOIN_PSK"), "Pre-shared key workers must know")
+	flags.StringVar(&cfg.ServeTokenTTL, "token-ttl", "15m", "Lifetime of the bootstrap tokens handed out")
+	flags.BoolVar(&cfg.ServeControlPlane, "allow-control-plane", false, "Also hand out certificate keys to nodes joining the control plane")
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	flags.Usage = showServeJoinHelp
+	flags.Parse(args)
+
+	if len(cfg.JoinPSK) < 16 {
+		fmt.Fprintln(os.Stderr, "Error: a pre-shared key of at least 16 characters is required, set --psk or GIK_JOIN_PSK")
+		os.Exit(1)
+	}
+	if ttl, err := time.ParseDuration(cfg.ServeTokenTTL); err != nil || ttl <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --token-ttl %q\n", cfg.ServeTokenTTL)
+		os.Exit(1)
+	}
+}
+
+var tokenIDPattern = regexp.MustCompile(`^[a-z0-9]{6}(\.[a-z0-9]{16})?$`)
+
+func parseToken(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandToken
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		showTokenHelp()
+		os.Exit(1)
+	}
+	cfg.Token.Action = args[0]
+
+	flags := flag.NewFlagSet(config.CommandToken, flag.ExitOnError)
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	configFile := flags.String("config", "", "Read settings from a YAML or JSON file")
+	if cfg.Token.Action == install.TokenCreate {
+		flags.StringVar(&cfg.Token.TTL, "ttl", "", "Token lifetime, 0 for never (default joinTokenTTL from the config)")
+		flags.StringVar(&cfg.Token.Description, "description", "", "Human readable description of the token")
+		flags.StringVar(&cfg.Token.Usages, "usages", "", "Comma separated token usages, e.g. signing,authentication")
+		flags.BoolVar(&cfg.Token.PrintJoinCommand, "print-join-command", false, "Print the full kubeadm join command")
+	}
+	flags.Usage = showTokenHelp
+	flags.Parse(args[1:])
+
+	loadSettings(cfg, *configFile)
+	validateSettings(cfg)
+
+	switch cfg.Token.Action {
+	case install.TokenCreate:
+		if cfg.Token.TTL == "" {
+			cfg.Token.TTL = cfg.JoinTokenTTL
+		}
+		if _, err := time.ParseDuration(cfg.Token.TTL); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --ttl %q\n", cfg.Token.TTL)
+			os.Exit(1)
+		}
+		if cfg.Token.Usages != "" {
+			if err := install.ValidateTokenUsages(cfg.Token.Usages); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	case install.TokenRevoke:
+		cfg.Token.ID = flags.Arg(0)
+		if !tokenIDPattern.MatchString(cfg.Token.ID) {
+			fmt.Fprintln(os.Stderr, "Error: token revoke needs a token ID, see token list")
+			os.Exit(1)
+		}
+	case install.TokenList, install.TokenRevokeExpired, install.TokenUploadCerts:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown token action: %s\n\n", cfg.Token.Action)
+		showTokenHelp()
+		os.Exit(1)
+	}
+}
+
+func parseCluster(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandCluster
+
+	if len(args) == 0 || args[0] != "apply" {
+		showClusterHelp()
+		os.Exit(1)
+	}
+	cfg.Cluster.Action = args[0]
+
+	flags := flag.NewFlagSet(config.CommandCluster, flag.ExitOnError)
+	flags.StringVar(&cfg.Cluster.Inventory, "i", "", "Inventory file listing the nodes")
+	flags.StringVar(&cfg.Cluster.Binary, "binary", "", "Binary to install with on the nodes (default: this one)")
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the ssh and scp commands without running them")
+	flags.Usage = showClusterHelp
+	flags.Parse(args[1:])
+
+	if cfg.Cluster.Inventory == "" {
+		fmt.Fprintln(os.Stderr, "Error: -i <inventory> is required")
+		showClusterHelp()
+		os.Exit(1)
+	}
+
+	// The nodes run the same binary, which must be built for Linux
+	if cfg.Cluster.Binary == "" {
+		binary, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Cluster.Binary = binary
+	}
+}
+
+func parseAddons(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandAddons
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		showAddonsHelp()
+		os.Exit(1)
+	}
+	cfg.Addon.Action = args[0]
+
+	flags := flag.NewFlagSet(config.CommandAddons, flag.ExitOnError)
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	configFile := flags.String("config", "", "Read settings from a YAML or JSON file")
+	if cfg.Addon.Action == install.AddonsEnable || cfg.Addon.Action == install.AddonsDisable {
+		flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the changes without making them")
+	}
+	flags.Usage = showAddonsHelp
+
+	// Names and flags may come in any order, but flag parsing stops at the
+	// first name
+	var names []string
+	for rest := args[1:]; ; rest = flags.Args()[1:] {
+		flags.Parse(rest)
+		if flags.NArg() == 0 {
+			break
+		}
+		names = append(names, config.SplitList(flags.Arg(0))...)
+	}
+
+	loadSettings(cfg, *configFile)
+	validateSettings(cfg)
+
+	switch cfg.Addon.Action {
+	case install.AddonsEnable, install.AddonsDisable:
+		cfg.Addon.Names = names
+		if len(cfg.Addon.Names) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: addons %s needs at least one addon name, see addons list\n", cfg.Addon.Action)
+			os.Exit(1)
+		}
+	case install.AddonsList, install.AddonsDiff:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown addons action: %s\n\n", cfg.Addon.Action)
+		showAddonsHelp()
+		os.Exit(1)
+	}
+}
+
+func parseBundle(cfg *config.Config, args []string) {
+	cfg.Command = config.CommandBundle
+
+	if len(args) == 0 || args[0] != install.BundleCreate {
+		showBundleHelp()
+		os.Exit(1)
+	}
+	cfg.Bundle.Action = args[0]
+
+	flags := flag.NewFlagSet(config.CommandBundle, flag.ExitOnError)
+	flags.StringVar(&cfg.Bundle.Output, "o", "go-install-kubernetes-bundle.tar.zst", "File to write the bundle to")
+	flags.BoolVar(&cfg.IsVerbose, "v", false, "Enable verbose output")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the commands and file writes without making them")
+	flags.StringVar(&cfg.Root, "root", "", "Write host files under this directory instead of /")
+	flags.StringVar(&cfg.OS, "os", "", "Release to plan for, such as ubuntu-24.04, instead of reading os-release")
+	configFile := flags.String("config", "", "Read settings from a YAML or JSON file")
+	cni := flags.String("cni", config.DefaultCNI, "Pod network to include: calico, flannel, cilium or none")
+	addons := flags.String("addons", strings.Join(config.DefaultAddons, ","), "Comma separated addons to include")
+	noAddons := flags.Bool("no-addons", false, "Include no addons")
+	flags.Usage = showBundleHelp
+	flags.Parse(args[1:])
+
+	loadSettings(cfg, *configFile)
+
+	// Flags given on the command line win over the file and environment
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cni":
+			cfg.CNI = *cni
+		case "addons":
+			cfg.Addons = config.SplitList(*addons)
+		}
+	})
+	if *noAddons {
+		cfg.Addons = nil
+	}
+
+	validateSettings(cfg)
+
+	// tar writes the bundle from another directory
+	output, err := filepath.Abs(cfg.Bundle.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.Bundle.Output = output
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"strings"
 
 	"go-install-kubernetes/pkg/config"
 )
@@ -9,6 +10,15 @@ import (
 func showHelp() {
 	fmt.Println("USAGE:")
 	fmt.Println("  go-install-kubernetes [options]")
+	fmt.Println("  go-install-kubernetes <command> [options]")
+	fmt.Println("\nCOMMANDS:")
+	fmt.Println("  reset  Remove Kubernetes and everything the installer set up from this node")
+	fmt.Println("  upgrade  Upgrade this node to a newer Kubernetes release")
+	fmt.Println("  serve-join  Hand out join details to workers from a control plane node")
+	fmt.Println("  token  Create, list and revoke bootstrap tokens")
+	fmt.Println("  cluster  Install every node in an inventory over SSH")
+	fmt.Println("  addons  List, enable and disable optional components on the cluster")
+	fmt.Println("  bundle  Create a bundle for installing without network access")
 	fmt.Println("\nOPTIONS:")
 	fmt.Println("  -c  Configure as a control plane node")
 	fmt.Println("  -w  Configure as a worker node")
@@ -16,14 +26,176 @@ func showHelp() {
 	fmt.Println("  -v  Enable verbose output")
 	fmt.Println("  -h  Show this help message")
 	fmt.Println("  --version  Show version information")
-	fmt.Println("  --export-manifests  Export embedded Calico manifests to disk")
-	fmt.Println("\nAt least one of -c, -w, or -s must be specified")
+	fmt.Println("  --config <file>  Read settings from a YAML or JSON file")
+	fmt.Println("  --pod-subnet <cidr>  Pod network CIDR (default 192.168.0.0/16)")
+	fmt.Println("  --service-subnet <cidr>  Service network CIDR (default 10.96.0.0/12)")
+	fmt.Println("  --cluster-domain <domain>  Cluster DNS domain (default cluster.local)")
+	fmt.Println("  --join-endpoint <host:port>  Join the cluster at this address after installing a worker")
+	fmt.Println("  --token <token>  Bootstrap token for --join-endpoint")
+	fmt.Println("  --discovery-token-ca-cert-hash <sha256:hex>  CA cert hash for --join-endpoint")
+	fmt.Println("  --join-token-ttl <duration>  Lifetime of the join token printed after install (default 24h)")
+	fmt.Println("  --join-server <url>  Fetch join details from a serve-join server")
+	fmt.Println("  --psk <key>  Pre-shared key for --join-server (or set GIK_JOIN_PSK)")
+	fmt.Println("  --join-command-file <file>  Read the join settings from a saved kubeadm join command")
+	fmt.Println("  --control-plane-endpoint <host[:port]>  Shared DNS name or virtual IP for an HA control plane")
+	fmt.Println("  --kube-vip <ip>  Announce this virtual IP with kube-vip and use it as the control plane endpoint")
+	fmt.Println("  --kube-vip-interface <name>  Interface to announce the kube-vip address on (default: the default route's)")
+	fmt.Println("  --join-control-plane  Join the cluster as an additional control plane node")
+	fmt.Println("  --certificate-key <hex>  Key for the uploaded certificates (or set GIK_CERTIFICATE_KEY)")
+	fmt.Println("  --cni <name>  Pod network to install: calico, flannel, cilium or none (default calico)")
+	fmt.Println("  --addons <list>  Comma separated addons to install (default metrics-server)")
+	fmt.Println("  --no-addons  Install no addons")
+	fmt.Println("  --local-path-dir <dir>  Directory on each node for local-path-provisioner volumes (default /opt/local-path-provisioner)")
+	fmt.Println("  --selinux-permissive  Switch an enforcing SELinux to permissive mode, as kubeadm requires")
+	fmt.Println("  --bundle <file>  Install without network access from a bundle made by bundle create")
+	fmt.Println("  --export-manifests  Export embedded manifests to disk")
+	fmt.Println("  --resume  Skip steps completed by a previous run and continue from the failure")
+	fmt.Println("  --dry-run  Print the commands and file writes without making them")
+	fmt.Println("  --root <dir>  Write host files under <dir> instead of /, needs --dry-run")
+	fmt.Println("  --os <release>  Plan for this release, such as ubuntu-24.04, instead of reading os-release")
+	fmt.Println("\nAt least one of -c, -w, -s or --join-control-plane must be specified")
+	fmt.Println("\nENVIRONMENT:")
+	fmt.Println("  GIK_KUBERNETES_VERSION, GIK_CONTAINERD_VERSION, GIK_CALICO_VERSION,")
+	fmt.Println("  GIK_KUBECTL_TIMEOUT, GIK_POD_SUBNET, GIK_SERVICE_SUBNET, GIK_CLUSTER_DOMAIN,")
+	fmt.Println("  GIK_JOIN_TOKEN_TTL, GIK_CONTROL_PLANE_ENDPOINT, GIK_KUBE_VIP, GIK_KUBE_VIP_INTERFACE,")
+	fmt.Println("  GIK_KUBE_VIP_VERSION, GIK_CNI, GIK_FLANNEL_VERSION, GIK_CILIUM_VERSION,")
+	fmt.Println("  GIK_ADDONS, GIK_LOCAL_PATH_DIR, GIK_IMAGE_REPOSITORY, GIK_CONTAINERD_SOURCE,")
+	fmt.Println("  GIK_RUNC_VERSION, GIK_CNI_PLUGINS_VERSION and GIK_KUBECONFIG_USER override the config file")
 }
 
-func printVersion() {
+func showResetHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes reset [options]")
+	fmt.Println("\nRuns kubeadm reset, removes CNI state and interfaces, purges the packages")
+	fmt.Println("and deletes every file the installer wrote.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --keep-packages  Leave the Kubernetes and containerd packages installed")
+	fmt.Println("  --dry-run  Print the commands and file removals without making them")
+	fmt.Println("  --root <dir>  Remove host files under <dir> instead of /, needs --dry-run")
+	fmt.Println("  --os <release>  Plan for this release, such as ubuntu-24.04, instead of reading os-release")
+}
+
+func showUpgradeHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes upgrade --to <version> [options]")
+	fmt.Println("\nUpgrades kubeadm, runs kubeadm upgrade apply on a control plane node or")
+	fmt.Println("kubeadm upgrade node on a worker, then drains the node, upgrades kubelet and")
+	fmt.Println("kubectl and uncordons it. Only the next minor release (or a newer patch")
+	fmt.Println("release) can be upgraded to. Upgrade the control plane before the workers.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  --to <version>  Kubernetes version to upgrade to, e.g. 1.32 or 1.32.3")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --config <file>  Read settings from a YAML or JSON file")
+	fmt.Println("  --dry-run  Print the commands and file writes without making them")
+	fmt.Println("  --root <dir>  Write host files under <dir> instead of /, needs --dry-run")
+	fmt.Println("  --os <release>  Plan for this release, such as ubuntu-24.04, instead of reading os-release")
+}
+
+func showServeJoinHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes serve-join --psk <key> [options]")
+	fmt.Println("\nServes short lived bootstrap tokens and the CA cert hash over HTTPS to workers")
+	fmt.Println("started with --join-server. Requests and replies are authenticated with the")
+	fmt.Println("pre-shared key, so only use this on a network you trust with that key.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  --listen <addr>  Address to listen on (default :9443)")
+	fmt.Println("  --psk <key>  Pre-shared key workers must know, at least 16 characters (or set GIK_JOIN_PSK)")
+	fmt.Println("  --token-ttl <duration>  Lifetime of the bootstrap tokens handed out (default 15m)")
+	fmt.Println("  --allow-control-plane  Also hand out certificate keys to nodes joining the control plane")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+}
+
+func showTokenHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes token create [options]")
+	fmt.Println("  go-install-kubernetes token list")
+	fmt.Println("  go-install-kubernetes token revoke <id>")
+	fmt.Println("  go-install-kubernetes token revoke-expired")
+	fmt.Println("  go-install-kubernetes token upload-certs")
+	fmt.Println("\nManages the bootstrap tokens used to join nodes. Run on a control plane node.")
+	fmt.Println("upload-certs re-uploads the control plane certificates and prints a new")
+	fmt.Println("certificate key for --join-control-plane, valid for 2 hours.")
+	fmt.Println("\nCREATE OPTIONS:")
+	fmt.Println("  --ttl <duration>  Token lifetime, 0 for never (default joinTokenTTL, 24h)")
+	fmt.Println("  --description <text>  Human readable description of the token")
+	fmt.Println("  --usages <list>  Comma separated token usages, e.g. signing,authentication")
+	fmt.Println("  --print-join-command  Print the full kubeadm join command")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --config <file>  Read settings from a YAML or JSON file")
+}
+
+func showClusterHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes cluster apply -i <inventory> [options]")
+	fmt.Println("\nConnects to the hosts in the inventory over SSH, uploads this binary and")
+	fmt.Println("installs the first control plane node, then any further control plane nodes")
+	fmt.Println("one at a time, then the workers in parallel, joining each to the cluster.")
+	fmt.Println("The SSH user must be root or able to sudo without a password.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  -i <file>  Inventory file listing the nodes")
+	fmt.Println("  --binary <file>  Binary to install with on the nodes (default: this one)")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --dry-run  Print the ssh and scp commands without running them")
+}
+
+func showAddonsHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes addons list")
+	fmt.Println("  go-install-kubernetes addons enable <name>... [options]")
+	fmt.Println("  go-install-kubernetes addons disable <name>... [options]")
+	fmt.Println("  go-install-kubernetes addons diff [options]")
+	fmt.Println("\nManages the optional components on an existing cluster. Run on a control")
+	fmt.Println("plane node. enable also enables the addons each one requires and waits for")
+	fmt.Println("them to be ready; disable deletes the addon's objects.")
+	fmt.Println("\ndiff shows how the live CNI and enabled addon objects differ from the")
+	fmt.Println("embedded manifests rendered with the current settings. The comparison is a")
+	fmt.Println("server-side dry-run apply, so only fields the installer sets are compared.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --config <file>  Read settings from a YAML or JSON file")
+	fmt.Println("  --dry-run  Print the changes without making them (enable and disable only)")
+}
+
+func showBundleHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  go-install-kubernetes bundle create [options]")
+	fmt.Println("\nDownloads the packages, with everything they depend on, and the container")
+	fmt.Println("images an install with the current settings needs, and writes them with the")
+	fmt.Println("rendered manifests to one archive. Install from it with --bundle on nodes")
+	fmt.Println("without network access. Run as root on a host with the same release and")
+	fmt.Println("architecture as the nodes, with containerd running for ctr to pull with.")
+	fmt.Println("\nOPTIONS:")
+	fmt.Println("  -o <file>  File to write the bundle to (default go-install-kubernetes-bundle.tar.zst)")
+	fmt.Println("  -v  Enable verbose output")
+	fmt.Println("  -h  Show this help message")
+	fmt.Println("  --config <file>  Read settings from a YAML or JSON file")
+	fmt.Println("  --cni <name>  Pod network to include: calico, flannel, cilium or none (default calico)")
+	fmt.Println("  --addons <list>  Comma separated addons to include (default metrics-server)")
+	fmt.Println("  --no-addons  Include no addons")
+	fmt.Println("  --dry-run  Print the commands and file writes without making them")
+	fmt.Println("  --root <dir>  Write host files under <dir> instead of /, needs --dry-run")
+	fmt.Println("  --os <release>  Plan for this release, such as ubuntu-24.04, instead of reading os-release")
+}
+
+func printVersion(cfg *config.Config) {
 	fmt.Printf("Install Kubernetes Version: %s\n", config.CLIVersion)
-	fmt.Printf("Kubernetes Version: %s\n", config.KubeVersion)
-	fmt.Printf("Containerd Version: %s\n", config.ContainerdVersion)
-	fmt.Printf("Calico Version: %s\n", config.CalicoVersion)
-	fmt.Printf("Ubuntu Version: %s\n", config.UbuntuVersion)
+	fmt.Printf("Kubernetes Version: %s\n", cfg.KubeVersion)
+	fmt.Printf("Containerd Version: %s\n", cfg.ContainerdVersion)
+	fmt.Printf("Containerd Source: %s\n", cfg.ContainerdSource)
+	fmt.Printf("runc Version: %s\n", cfg.RuncVersion)
+	fmt.Printf("CNI Plugins Version: %s\n", cfg.CNIPluginsVersion)
+	fmt.Printf("CNI: %s\n", cfg.CNI)
+	fmt.Printf("Calico Version: %s\n", cfg.CalicoVersion)
+	fmt.Printf("Flannel Version: %s\n", cfg.FlannelVersion)
+	fmt.Printf("Cilium Version: %s\n", cfg.CiliumVersion)
+	fmt.Printf("Addons: %s\n", strings.Join(cfg.Addons, ","))
+	fmt.Printf("kube-vip Version: %s\n", cfg.KubeVIPVersion)
 }
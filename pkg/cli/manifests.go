@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"strings"
 
 	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/install"
 )
 
 func exportEmbeddedFiles(cfg *config.Config, manifestFiles fs.FS) error {
@@ -25,11 +27,12 @@ func exportEmbeddedFiles(cfg *config.Config, manifestFiles fs.FS) error {
 			return os.MkdirAll(path, 0755)
 		}
 
-		// Read and write each file
-		content, err := fs.ReadFile(manifestFiles, path)
+		// Read and write each file, rendering templates with the current config
+		content, err := install.RenderManifest(cfg, manifestFiles, path)
 		if err != nil {
 			return fmt.Errorf("failed to read embedded file %s: %v", path, err)
 		}
+		path = strings.TrimSuffix(path, ".tmpl")
 
 		if err := os.WriteFile(path, content, 0644); err != nil {
 			return fmt.Errorf("failed to export file %s: %v", path, err)
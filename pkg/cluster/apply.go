@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/joinserver"
+
+	"gopkg.in/yaml.v3"
+)
+
+// remoteDirTemplate is the mktemp template for the directory the binary and
+// its inputs are uploaded to on each host. A fresh directory owned by the
+// ssh user is made for every install, so no other user on the host can
+// plant files in it beforehand.
+const remoteDirTemplate = "/tmp/go-install-kubernetes-cluster.XXXXXXXXXX"
+
+// remoteDirPattern matches what mktemp makes of remoteDirTemplate. The path
+// ends up in single quoted ssh commands, so nothing else is accepted.
+var remoteDirPattern = regexp.MustCompile(`^/tmp/go-install-kubernetes-cluster\.[A-Za-z0-9]+$`)
+
+// applier installs the nodes of an inventory, one host at a time for the
+// control plane and in parallel for the workers.
+type applier struct {
+	cfg      *config.Config
+	inv      *Inventory
+	out      io.Writer
+	mu       sync.Mutex
+	localDir string
+	settings string
+}
+
+// Apply installs every node in the inventory: the first control plane node,
+// then any further control plane nodes, then the workers, joining each to
+// the cluster. All output goes to out, each line prefixed with its host.
+func Apply(cfg *config.Config, out io.Writer) error {
+	inv, err := LoadInventory(cfg.Cluster.Inventory)
+	if err != nil {
+		return err
+	}
+
+	localDir, err := os.MkdirTemp("", "go-install-kubernetes-cluster-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(localDir)
+
+	settings, err := yaml.Marshal(inv.Settings)
+	if err != nil {
+		return err
+	}
+	a := &applier{cfg: cfg, inv: inv, out: out, localDir: localDir, settings: filepath.Join(localDir, "settings.yaml")}
+	// The settings may hold registry passwords
+	if err := os.WriteFile(a.settings, settings, 0600); err != nil {
+		return err
+	}
+
+	first := inv.ControlPlane[0]
+	role := "-c"
+	if len(inv.ControlPlane) == 1 && len(inv.Workers) == 0 {
+		role = "-s"
+	}
+	fmt.Fprintf(out, "Installing control plane on %s...\n", first.Address)
+	if err := a.install(first, role, ""); err != nil {
+		return fmt.Errorf("control plane %s failed: %v", first.Address, err)
+	}
+	if len(inv.ControlPlane) == 1 && len(inv.Workers) == 0 {
+		return nil
+	}
+
+	firstOut := a.writer(first)
+	defer firstOut.Flush()
+	firstRemote := a.remote(first, firstOut)
+	joinCmd, tokenID, err := a.joinCommand(firstRemote)
+	if err != nil {
+		return err
+	}
+	// The token is only needed while the nodes join
+	defer firstRemote.Run(fmt.Sprintf("kubeadm token delete %s", tokenID))
+
+	// etcd members are added one at a time, so these run in order
+	if len(inv.ControlPlane) > 1 {
+		key, err := joinserver.UploadCerts(firstRemote)
+		if err != nil && cfg.DryRun {
+			key, err = "<certificate-key>", nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload certificates: %v", err)
+		}
+		joinFile, err := a.writeJoinFile("join-control-plane.txt", joinCmd+" --control-plane --certificate-key "+key)
+		if err != nil {
+			return err
+		}
+		for _, host := range inv.ControlPlane[1:] {
+			fmt.Fprintf(out, "Joining control plane node %s...\n", host.Address)
+			if err := a.install(host, "--join-control-plane", joinFile); err != nil {
+				return fmt.Errorf("control plane %s failed: %v", host.Address, err)
+			}
+		}
+	}
+
+	if len(inv.Workers) == 0 {
+		return nil
+	}
+	joinFile, err := a.writeJoinFile("join.txt", joinCmd)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Installing %d worker(s), %d at a time...\n", len(inv.Workers), inv.Concurrency)
+	var (
+		wg     sync.WaitGroup
+		failMu sync.Mutex
+		failed []string
+		slots  = make(chan struct{}, inv.Concurrency)
+	)
+	for _, host := range inv.Workers {
+		wg.Add(1)
+		go func(host Host) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if err := a.install(host, "-w", joinFile); err != nil {
+				fmt.Fprintf(a.writer(host), "failed: %v\n", err)
+				failMu.Lock()
+				failed = append(failed, host.Address)
+				failMu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("workers failed: %s", strings.Join(failed, ", "))
+	}
+	fmt.Fprintf(out, "All %d node(s) installed\n", len(inv.ControlPlane)+len(inv.Workers))
+	return nil
+}
+
+// writer returns a writer that prefixes each line with the host.
+func (a *applier) writer(host Host) *prefixWriter {
+	return &prefixWriter{mu: &a.mu, out: a.out, prefix: "[" + host.Address + "] "}
+}
+
+func (a *applier) remote(host Host, w io.Writer) *remote {
+	if a.cfg.DryRun {
+		return &remote{host: host, r: exec.NewDryRunner(w)}
+	}
+	return &remote{host: host, r: exec.NewStreamRunner(w)}
+}
+
+// install uploads the binary and settings to host and runs the install there
+// with the given role flags, joining with joinFile if one is given.
+func (a *applier) install(host Host, role, joinFile string) error {
+	w := a.writer(host)
+	defer w.Flush()
+	m := a.remote(host, w)
+
+	remoteDir, err := a.makeRemoteDir(m)
+	if err != nil {
+		return err
+	}
+	defer m.shell(fmt.Sprintf("rm -rf %s", remoteDir))
+
+	binary := remoteDir + "/go-install-kubernetes"
+	settings := remoteDir + "/settings.yaml"
+	if err := m.upload(a.cfg.Cluster.Binary, binary); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", a.cfg.Cluster.Binary, err)
+	}
+	if err := m.upload(a.settings, settings); err != nil {
+		return fmt.Errorf("failed to upload settings: %v", err)
+	}
+
+	cmd := fmt.Sprintf("%s %s --config %s", binary, role, settings)
+	if joinFile != "" {
+		remoteJoinFile := remoteDir + "/" + filepath.Base(joinFile)
+		if err := m.upload(joinFile, remoteJoinFile); err != nil {
+			return fmt.Errorf("failed to upload join command: %v", err)
+		}
+		cmd += " --join-command-file " + remoteJoinFile
+	}
+	if a.cfg.IsVerbose {
+		cmd += " -v"
+	}
+
+	_, err = m.Run(cmd)
+	return err
+}
+
+// makeRemoteDir creates a private temporary directory on the host with
+// mktemp and returns its path. A dry run creates nothing, so the template
+// stands in for the path.
+func (a *applier) makeRemoteDir(m *remote) (string, error) {
+	res, err := m.shell("mktemp -d " + remoteDirTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary directory: %v", err)
+	}
+	if a.cfg.DryRun {
+		return remoteDirTemplate, nil
+	}
+
+	dir := strings.TrimSpace(res.Stdout)
+	if !remoteDirPattern.MatchString(dir) {
+		return "", fmt.Errorf("mktemp returned an unexpected directory %q", dir)
+	}
+	return dir, nil
+}
+
+// joinCommand creates a bootstrap token on the first control plane node and
+// returns its join command and the token ID.
+func (a *applier) joinCommand(m *remote) (string, string, error) {
+	res, err := m.Run(fmt.Sprintf("kubeadm token create --print-join-command --ttl %s", a.inv.Settings.JoinTokenTTL))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create join token: %v", err)
+	}
+
+	joinCmd := strings.TrimSpace(res.Stdout)
+	var join config.Config
+	if err := config.ParseJoinCommand(joinCmd, &join); err != nil {
+		if !a.cfg.DryRun {
+			return "", "", err
+		}
+		return "kubeadm join <endpoint> --token <token> --discovery-token-ca-cert-hash <ca-cert-hash>", "<token-id>", nil
+	}
+	return joinCmd, strings.SplitN(join.JoinToken, ".", 2)[0], nil
+}
+
+// writeJoinFile saves a join command for upload. It holds a token, so only
+// the owner may read it; scp keeps the mode on the other end.
+func (a *applier) writeJoinFile(name, joinCmd string) (string, error) {
+	path := filepath.Join(a.localDir, name)
+	return path, os.WriteFile(path, []byte(joinCmd+"\n"), 0600)
+}
@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+)
+
+func writeInventory(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadInventory(t *testing.T) {
+	t.Setenv("HOME", "/home/admin")
+	inv, err := LoadInventory(writeInventory(t, `
+ssh:
+  user: ubuntu
+  identityFile: ~/.ssh/id_ed25519
+controlPlane:
+  - address: 10.0.0.10
+workers:
+  - address: 10.0.0.20
+  - address: 10.0.0.21
+    user: root
+    port: 2222
+    identityFile: /keys/worker
+settings:
+  kubernetesVersion: 1.31.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.Concurrency != DefaultConcurrency {
+		t.Errorf("concurrency %d, want %d", inv.Concurrency, DefaultConcurrency)
+	}
+	want := []Host{
+		{Address: "10.0.0.10", User: "ubuntu", Port: 22, IdentityFile: "/home/admin/.ssh/id_ed25519"},
+		{Address: "10.0.0.20", User: "ubuntu", Port: 22, IdentityFile: "/home/admin/.ssh/id_ed25519"},
+		{Address: "10.0.0.21", User: "root", Port: 2222, IdentityFile: "/keys/worker"},
+	}
+	for i, host := range inv.hosts() {
+		if host != want[i] {
+			t.Errorf("host %d is %+v, want %+v", i, host, want[i])
+		}
+	}
+	// Settings not in the file keep their defaults
+	if inv.Settings.KubeVersion != "1.31.5" || inv.Settings.CNI != config.New().CNI {
+		t.Errorf("settings not merged with the defaults: %+v", inv.Settings)
+	}
+}
+
+func TestLoadInventoryInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"no control plane", "workers:\n  - address: 10.0.0.20\n", "at least one controlPlane"},
+		{"no shared endpoint", "controlPlane:\n  - address: a\n  - address: b\n", "controlPlaneEndpoint or kubeVIP"},
+		{"duplicate host", "controlPlane:\n  - address: a\nworkers:\n  - address: a\n", "listed more than once"},
+		{"missing address", "controlPlane:\n  - user: root\n", "needs an address"},
+		{"bad port", "controlPlane:\n  - address: a\n    port: 70000\n", "invalid ssh port"},
+		{"bad concurrency", "concurrency: 0\ncontrolPlane:\n  - address: a\n", "invalid concurrency"},
+		{"unknown key", "controlplane:\n  - address: a\n", "field controlplane not found"},
+		{"bad settings", "controlPlane:\n  - address: a\nsettings:\n  kubernetesVersion: latest\n", "invalid settings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadInventory(writeInventory(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRemote(t *testing.T) {
+	r := exec.NewRecorder()
+	m := &remote{host: Host{Address: "10.0.0.20", User: "ubuntu", Port: 2222, IdentityFile: "/keys/id"}, r: r}
+
+	m.Run("kubeadm reset -f")
+	m.shell("mkdir -p /tmp/x")
+	m.upload("/tmp/settings.yaml", "/tmp/x/settings.yaml")
+
+	opts := "-o BatchMode=yes -o StrictHostKeyChecking=accept-new"
+	err := r.Expect(
+		"ssh "+opts+" -p 2222 -i /keys/id ubuntu@10.0.0.20 'sudo kubeadm reset -f'",
+		"ssh "+opts+" -p 2222 -i /keys/id ubuntu@10.0.0.20 'mkdir -p /tmp/x'",
+		"scp -q "+opts+" -P 2222 -i /keys/id /tmp/settings.yaml ubuntu@10.0.0.20:/tmp/x/settings.yaml",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{mu: &mu, out: &out, prefix: "[a] "}
+	b := &prefixWriter{mu: &mu, out: &out, prefix: "[b] "}
+
+	a.Write([]byte("first "))
+	b.Write([]byte("one\ntwo\nthr"))
+	a.Write([]byte("line\n"))
+	b.Flush()
+	a.Flush()
+
+	want := "[b] one\n[b] two\n[a] first line\n[b] thr\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestMakeRemoteDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		stdout string
+		want   string
+		err    string
+	}{
+		{"created", "/tmp/go-install-kubernetes-cluster.a1B2c3D4e5\n", "/tmp/go-install-kubernetes-cluster.a1B2c3D4e5", ""},
+		{"elsewhere", "/home/ubuntu/go-install-kubernetes-cluster.a1B2c3D4e5\n", "", "unexpected directory"},
+		{"quote", "/tmp/go-install-kubernetes-cluster.a1'b2\n", "", "unexpected directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := exec.NewRecorder().On("ssh", exec.Result{Stdout: tt.stdout})
+			a := &applier{cfg: config.New()}
+			m := &remote{host: Host{Address: "w1", User: "ubuntu", Port: 22}, r: r}
+
+			got, err := a.makeRemoteDir(m)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			// Made as the ssh user, not root, so the user can upload into it
+			if cmds := r.Commands(); len(cmds) != 1 || !strings.HasSuffix(cmds[0], "ubuntu@w1 'mktemp -d "+remoteDirTemplate+"'") {
+				t.Errorf("ran %q", cmds)
+			}
+		})
+	}
+}
+
+// TestApplyDryRun checks the order a dry run plans an HA cluster in: the
+// first control plane node, a token and certificate key from it, the other
+// control plane nodes and then the worker.
+func TestApplyDryRun(t *testing.T) {
+	cfg := config.New()
+	cfg.DryRun = true
+	cfg.Cluster.Binary = "/usr/local/bin/go-install-kubernetes"
+	cfg.Cluster.Inventory = writeInventory(t, `
+controlPlane:
+  - address: cp1
+  - address: cp2
+workers:
+  - address: w1
+settings:
+  kubeVIP: 10.0.0.100
+`)
+
+	var out bytes.Buffer
+	if err := Apply(cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteDir := remoteDirTemplate
+	remoteBinary := remoteDir + "/go-install-kubernetes"
+	want := []string{
+		"Installing control plane on cp1...",
+		"[cp1]   run:   ssh " + Host{Port: 22}.sshOptions("-p") + " root@cp1 'mktemp -d " + remoteDirTemplate + "'",
+		"[cp1]   run:   scp -q ",
+		"[cp1]   run:   ssh " + Host{Port: 22}.sshOptions("-p") + " root@cp1 '" + remoteBinary + " -c --config ",
+		"[cp1]   run:   ssh ",
+		"kubeadm token create --print-join-command --ttl 24h",
+		"kubeadm init phase upload-certs --upload-certs",
+		"Joining control plane node cp2...",
+		"'" + remoteBinary + " --join-control-plane --config " + remoteDir + "/settings.yaml --join-command-file " + remoteDir + "/join-control-plane.txt'",
+		"Installing 1 worker(s), 5 at a time...",
+		"'" + remoteBinary + " -w --config " + remoteDir + "/settings.yaml --join-command-file " + remoteDir + "/join.txt'",
+		"All 3 node(s) installed",
+		"[cp1]   run:   ssh ",
+		"kubeadm token delete <token-id>",
+	}
+	rest := out.String()
+	for _, s := range want {
+		i := strings.Index(rest, s)
+		if i < 0 {
+			t.Fatalf("%q not found in order, output:\n%s", s, out.String())
+		}
+		rest = rest[i+len(s):]
+	}
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConcurrency is how many workers are installed at once.
+const DefaultConcurrency = 5
+
+// Inventory lists the nodes of a cluster and how to reach them.
+type Inventory struct {
+	SSH          SSH            `yaml:"ssh"`
+	Concurrency  int            `yaml:"concurrency"`
+	ControlPlane []Host         `yaml:"controlPlane"`
+	Workers      []Host         `yaml:"workers"`
+	Settings     *config.Config `yaml:"settings"`
+}
+
+// SSH holds the connection defaults for every host.
+type SSH struct {
+	User         string `yaml:"user"`
+	Port         int    `yaml:"port"`
+	IdentityFile string `yaml:"identityFile"`
+}
+
+// Host is one node. Empty connection fields fall back to the SSH defaults.
+type Host struct {
+	Address      string `yaml:"address"`
+	User         string `yaml:"user"`
+	Port         int    `yaml:"port"`
+	IdentityFile string `yaml:"identityFile"`
+}
+
+// LoadInventory reads an inventory file. The settings section takes the
+// same keys as a --config file and is passed on to every node.
+func LoadInventory(path string) (*Inventory, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %v", err)
+	}
+
+	inv := &Inventory{
+		SSH:         SSH{User: "root", Port: 22},
+		Concurrency: DefaultConcurrency,
+		Settings:    config.New(),
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(inv); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse inventory %s: %v", path, err)
+	}
+
+	for i := range inv.ControlPlane {
+		inv.ControlPlane[i].applyDefaults(inv.SSH)
+	}
+	for i := range inv.Workers {
+		inv.Workers[i].applyDefaults(inv.SSH)
+	}
+	return inv, inv.validate()
+}
+
+func (h *Host) applyDefaults(defaults SSH) {
+	if h.User == "" {
+		h.User = defaults.User
+	}
+	if h.Port == 0 {
+		h.Port = defaults.Port
+	}
+	if h.IdentityFile == "" {
+		h.IdentityFile = defaults.IdentityFile
+	}
+	// Commands aren't run through a shell, so expand ~ here
+	if rest, ok := strings.CutPrefix(h.IdentityFile, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			h.IdentityFile = filepath.Join(home, rest)
+		}
+	}
+}
+
+func (inv *Inventory) validate() error {
+	if len(inv.ControlPlane) == 0 {
+		return fmt.Errorf("inventory needs at least one controlPlane host")
+	}
+	if len(inv.ControlPlane) > 1 && !inv.Settings.HighlyAvailable() {
+		return fmt.Errorf("more than one controlPlane host needs controlPlaneEndpoint or kubeVIP in settings")
+	}
+	if inv.Concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d", inv.Concurrency)
+	}
+
+	seen := map[string]bool{}
+	for _, host := range inv.hosts() {
+		if host.Address == "" {
+			return fmt.Errorf("every host needs an address")
+		}
+		if seen[host.Address] {
+			return fmt.Errorf("host %s is listed more than once", host.Address)
+		}
+		seen[host.Address] = true
+		if host.Port < 1 || host.Port > 65535 {
+			return fmt.Errorf("invalid ssh port %d for host %s", host.Port, host.Address)
+		}
+	}
+
+	if err := inv.Settings.Validate(); err != nil {
+		return fmt.Errorf("invalid settings: %v", err)
+	}
+	return nil
+}
+
+func (inv *Inventory) hosts() []Host {
+	return append(append([]Host{}, inv.ControlPlane...), inv.Workers...)
+}
+
+// target is the user@address ssh connects to.
+func (h Host) target() string {
+	return h.User + "@" + h.Address
+}
+
+// sshOptions are the options shared by ssh and scp, which differ only in
+// how the port is given.
+func (h Host) sshOptions(portFlag string) string {
+	opts := "-o BatchMode=yes -o StrictHostKeyChecking=accept-new " + portFlag + " " + strconv.Itoa(h.Port)
+	if h.IdentityFile != "" {
+		opts += " -i " + h.IdentityFile
+	}
+	return opts
+}
@@ -0,0 +1,34 @@
+package cluster
+
+import (
+	"fmt"
+
+	"go-install-kubernetes/pkg/exec"
+)
+
+// remote runs commands on a host over ssh. It is itself a Runner, running
+// every command as root, so the install package helpers work against it.
+type remote struct {
+	host Host
+	r    exec.Runner
+}
+
+// Run runs cmd as root on the host. The command is single quoted for the
+// local side, so it must not contain single quotes itself.
+func (m *remote) Run(cmd string) (exec.Result, error) {
+	if m.host.User != "root" {
+		cmd = "sudo " + cmd
+	}
+	return m.shell(cmd)
+}
+
+// shell runs cmd as the ssh user.
+func (m *remote) shell(cmd string) (exec.Result, error) {
+	return m.r.Run(fmt.Sprintf("ssh %s %s '%s'", m.host.sshOptions("-p"), m.host.target(), cmd))
+}
+
+// upload copies a local file to path on the host, keeping its mode.
+func (m *remote) upload(local, path string) error {
+	_, err := m.r.Run(fmt.Sprintf("scp -q %s %s %s:%s", m.host.sshOptions("-P"), local, m.host.target(), path))
+	return err
+}
@@ -1,18 +1,287 @@
 package config
 
+import (
+	"slices"
+	"strings"
+)
+
+// Commands the binary can run; install is the default
+const (
+	CommandInstall   = "install"
+	CommandReset     = "reset"
+	CommandUpgrade   = "upgrade"
+	CommandServeJoin = "serve-join"
+	CommandToken     = "token"
+	CommandCluster   = "cluster"
+	CommandAddons    = "addons"
+	CommandBundle    = "bundle"
+)
+
 type Config struct {
-	IsControlNode bool
-	IsWorkerNode  bool
-	IsSingleNode  bool
-	IsVerbose     bool
-	LogFile       string
+	Command           string         `yaml:"-"`
+	IsControlNode     bool           `yaml:"-"`
+	IsWorkerNode      bool           `yaml:"-"`
+	IsSingleNode      bool           `yaml:"-"`
+	IsVerbose         bool           `yaml:"-"`
+	LogFile           string         `yaml:"-"`
+	Root              string         `yaml:"-"`
+	OS                string         `yaml:"-"`
+	DryRun            bool           `yaml:"-"`
+	Resume            bool           `yaml:"-"`
+	KeepPackages      bool           `yaml:"-"`
+	UpgradeTo         string         `yaml:"-"`
+	JoinEndpoint      string         `yaml:"-"`
+	JoinToken         string         `yaml:"-"`
+	JoinCAHash        string         `yaml:"-"`
+	JoinServer        string         `yaml:"-"`
+	JoinPSK           string         `yaml:"-"`
+	ServeControlPlane bool           `yaml:"-"`
+	JoinControlPlane  bool           `yaml:"-"`
+	CertificateKey    string         `yaml:"-"`
+	ServeListen       string         `yaml:"-"`
+	ServeTokenTTL     string         `yaml:"-"`
+	Token             TokenOptions   `yaml:"-"`
+	Cluster           ClusterOptions `yaml:"-"`
+	Addon             AddonOptions   `yaml:"-"`
+	Bundle            BundleOptions  `yaml:"-"`
+	BundlePath        string         `yaml:"-"`
+
+	// Settings below can come from a --config file or the environment
+	KubeVersion       string `yaml:"kubernetesVersion"`
+	ContainerdVersion string `yaml:"containerdVersion"`
+	CalicoVersion     string `yaml:"calicoVersion"`
+	KubectlTimeout    string `yaml:"kubectlTimeout"`
+	PodSubnet         string `yaml:"podSubnet"`
+	ServiceSubnet     string `yaml:"serviceSubnet"`
+	ClusterDomain     string `yaml:"clusterDomain"`
+	JoinTokenTTL      string `yaml:"joinTokenTTL"`
+
+	// ControlPlaneEndpoint is a DNS name or virtual IP, optionally with a
+	// port, shared by all control plane nodes. Setting it enables HA mode.
+	ControlPlaneEndpoint string `yaml:"controlPlaneEndpoint"`
+
+	// KubeVIP is a virtual IP announced over ARP by a kube-vip static pod on
+	// the control plane nodes. It is the default control plane endpoint.
+	KubeVIP          string `yaml:"kubeVIP"`
+	KubeVIPInterface string `yaml:"kubeVIPInterface"`
+	KubeVIPVersion   string `yaml:"kubeVIPVersion"`
+
+	// CNI is the pod network installed on the control plane, one of CNIs.
+	CNI            string `yaml:"cni"`
+	FlannelVersion string `yaml:"flannelVersion"`
+	CiliumVersion  string `yaml:"ciliumVersion"`
+
+	// Addons are the optional components installed after the pod network.
+	Addons []string `yaml:"addons"`
+
+	// LocalPathDir is where the local-path-provisioner addon creates the
+	// volumes on each node.
+	LocalPathDir string `yaml:"localPathDir"`
+
+	// Registries tell containerd how to reach image registries: through
+	// mirrors, trusting a private CA, or logging in.
+	Registries []Registry `yaml:"registries"`
+
+	// ImageRepository replaces registry.k8s.io for the images kubeadm
+	// pulls, such as an internal copy of them.
+	ImageRepository string `yaml:"imageRepository"`
+
+	// ContainerdSource is where containerd comes from, one of
+	// ContainerdSources. Only a release install gets exactly
+	// ContainerdVersion, with RuncVersion and CNIPluginsVersion.
+	ContainerdSource  string `yaml:"containerdSource"`
+	RuncVersion       string `yaml:"runcVersion"`
+	CNIPluginsVersion string `yaml:"cniPluginsVersion"`
+
+	// Containerd adjusts the config.toml written for containerd.
+	Containerd ContainerdOptions `yaml:"containerd"`
+
+	// SELinuxPermissive lets the install switch an enforcing SELinux to
+	// permissive mode, which kubeadm requires. Unset, it only warns.
+	SELinuxPermissive bool `yaml:"selinuxPermissive"`
+
+	// KubeconfigUser also gets a copy of the admin kubeconfig, in their
+	// home directory. Unset, it is the user who ran sudo, if any.
+	KubeconfigUser string `yaml:"kubeconfigUser"`
+}
+
+// ContainerdOptions adjust containerd's config.toml.
+type ContainerdOptions struct {
+	// SandboxImage is the pause image every pod starts with, by default
+	// the one kubeadm pulls for the Kubernetes version.
+	SandboxImage string `yaml:"sandboxImage"`
+	// Root is where containerd keeps images and container filesystems.
+	Root        string `yaml:"root"`
+	Snapshotter string `yaml:"snapshotter"`
+	// SystemdCgroup has runc manage cgroups through systemd, which must
+	// match the kubelet's cgroup driver. Unset, it follows whether systemd
+	// runs the node.
+	SystemdCgroup *bool `yaml:"systemdCgroup"`
+	// Runtimes are added beside runc. A RuntimeClass selects one by using
+	// its name as the handler.
+	Runtimes []ContainerdRuntime `yaml:"runtimes"`
+}
+
+// ContainerdRuntime is an additional runtime, such as gVisor or Kata
+// Containers, or a runc compatible binary such as crun.
+type ContainerdRuntime struct {
+	Name string `yaml:"name"`
+	// Type is the containerd shim, io.containerd.runc.v2 by default.
+	Type string `yaml:"type"`
+	// BinaryName replaces runc for the io.containerd.runc.v2 shim.
+	BinaryName string `yaml:"binaryName"`
 }
 
+// Registry configures containerd's access to one image registry.
+type Registry struct {
+	// Host is the registry as image names refer to it, such as docker.io
+	// or registry.internal:5000.
+	Host string `yaml:"host"`
+	// Server is the registry's URL, https://<host> by default. Use an
+	// http:// URL for a registry without TLS.
+	Server string `yaml:"server"`
+	// Mirrors are URLs tried in order before the server.
+	Mirrors []string `yaml:"mirrors"`
+	// CAFile is a CA bundle on the node that the server and mirrors are
+	// verified against.
+	CAFile string `yaml:"caFile"`
+	// Insecure skips verifying their certificates altogether.
+	Insecure bool `yaml:"insecure"`
+	// Username and Password log in to the server, not the mirrors. A
+	// mirror that needs a login gets a registry entry of its own.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// ServerURL returns the URL images are pulled from when no mirror has them.
+// Docker Hub is served from a different host than image names use.
+func (r Registry) ServerURL() string {
+	switch {
+	case r.Server != "":
+		return strings.TrimSuffix(r.Server, "/")
+	case r.Host == "docker.io":
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + r.Host
+}
+
+// Pod networks the installer can set up. With CNINone the nodes stay
+// NotReady until one is installed by other means.
+const (
+	CNICalico  = "calico"
+	CNIFlannel = "flannel"
+	CNICilium  = "cilium"
+	CNINone    = "none"
+)
+
+// CNIs lists the accepted values of the cni setting.
+var CNIs = []string{CNICalico, CNIFlannel, CNICilium, CNINone}
+
+// Where containerd is installed from. ContainerdSourcePackage takes the
+// distribution's package, whatever its version, while
+// ContainerdSourceRelease installs the upstream release tarballs.
 const (
-	KubeVersion       = "1.31.5"
-	ContainerdVersion = "1.7.20"
-	CalicoVersion     = "3.27.5"
-	UbuntuVersion     = "22.04"
-	KubectlTimeout    = "300s"
-	CLIVersion        = "0.3.2"
+	ContainerdSourcePackage = "package"
+	ContainerdSourceRelease = "release"
 )
+
+// ContainerdSources lists the accepted values of the containerdSource
+// setting.
+var ContainerdSources = []string{ContainerdSourcePackage, ContainerdSourceRelease}
+
+// TokenOptions are the settings for the token command.
+type TokenOptions struct {
+	Action           string
+	ID               string
+	TTL              string
+	Description      string
+	Usages           string
+	PrintJoinCommand bool
+}
+
+// ClusterOptions are the settings for the cluster command.
+type ClusterOptions struct {
+	Action    string
+	Inventory string
+	Binary    string
+}
+
+// AddonOptions are the settings for the addons command.
+type AddonOptions struct {
+	Action string
+	Names  []string
+}
+
+// BundleOptions are the settings for the bundle command.
+type BundleOptions struct {
+	Action string
+	Output string
+}
+
+// tigeraOperatorVersions maps each Calico release to the Tigera operator
+// release that deploys it. The operator picks the Calico images, so this is
+// what calicoVersion changes. Only releases whose CRDs match the embedded
+// operator manifest are listed.
+var tigeraOperatorVersions = map[string]string{
+	"3.27.0": "1.32.3",
+	"3.27.2": "1.32.5",
+	"3.27.3": "1.32.7",
+	"3.27.4": "1.32.10",
+	"3.27.5": "1.32.12",
+}
+
+// Defaults used when neither the config file nor the environment say otherwise
+const (
+	DefaultKubeVersion       = "1.31.5"
+	DefaultContainerdVersion = "1.7.20"
+	DefaultContainerdSource  = ContainerdSourcePackage
+	DefaultRuncVersion       = "1.1.13"
+	DefaultCNIPluginsVersion = "1.5.1"
+	DefaultCalicoVersion     = "3.27.5"
+	DefaultKubectlTimeout    = "300s"
+	DefaultPodSubnet         = "192.168.0.0/16"
+	DefaultServiceSubnet     = "10.96.0.0/12"
+	DefaultClusterDomain     = "cluster.local"
+	DefaultJoinTokenTTL      = "24h"
+	DefaultKubeVIPVersion    = "0.8.9"
+	DefaultCNI               = CNICalico
+	DefaultFlannelVersion    = "0.26.4"
+	DefaultCiliumVersion     = "1.16.6"
+	DefaultLocalPathDir      = "/opt/local-path-provisioner"
+	DefaultContainerdRoot    = "/var/lib/containerd"
+	DefaultSnapshotter       = "overlayfs"
+	DefaultRuntimeType       = "io.containerd.runc.v2"
+)
+
+// DefaultAddons are installed unless the settings say otherwise.
+var DefaultAddons = []string{"metrics-server"}
+
+const CLIVersion = "0.3.2"
+
+// New returns a Config populated with the defaults.
+func New() *Config {
+	return &Config{
+		Command:           CommandInstall,
+		KubeVersion:       DefaultKubeVersion,
+		ContainerdVersion: DefaultContainerdVersion,
+		CalicoVersion:     DefaultCalicoVersion,
+		KubectlTimeout:    DefaultKubectlTimeout,
+		PodSubnet:         DefaultPodSubnet,
+		ServiceSubnet:     DefaultServiceSubnet,
+		ClusterDomain:     DefaultClusterDomain,
+		JoinTokenTTL:      DefaultJoinTokenTTL,
+		KubeVIPVersion:    DefaultKubeVIPVersion,
+		CNI:               DefaultCNI,
+		FlannelVersion:    DefaultFlannelVersion,
+		CiliumVersion:     DefaultCiliumVersion,
+		Addons:            slices.Clone(DefaultAddons),
+		LocalPathDir:      DefaultLocalPathDir,
+		ContainerdSource:  DefaultContainerdSource,
+		RuncVersion:       DefaultRuncVersion,
+		CNIPluginsVersion: DefaultCNIPluginsVersion,
+		Containerd: ContainerdOptions{
+			Root:        DefaultContainerdRoot,
+			Snapshotter: DefaultSnapshotter,
+		},
+	}
+}
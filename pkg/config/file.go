@@ -0,0 +1,368 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	patchVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	domainPattern       = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	interfacePattern    = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+	hostPathPattern     = regexp.MustCompile(`^(/[a-zA-Z0-9_.-]+)+$`)
+	registryHostPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*(:\d{1,5})?$`)
+	imagePattern        = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*(:\d{1,5})?(/[a-z0-9]+([._-][a-z0-9]+)*)+(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+	nameLabelPattern    = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	runtimeTypePattern  = regexp.MustCompile(`^[a-z0-9]+(\.[a-z0-9]+)+$`)
+	binaryPattern       = regexp.MustCompile(`^[a-zA-Z0-9_./-]+$`)
+	userPattern         = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	repositoryPattern   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*(:\d{1,5})?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+)
+
+// Load reads a YAML (or JSON) config file into cfg. Settings missing from
+// the file keep their current values, and unknown keys are rejected so that
+// typos don't silently fall back to a default.
+func Load(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings from GIK_* environment variables, which take
+// precedence over the config file.
+func ApplyEnv(cfg *Config) {
+	vars := []struct {
+		name  string
+		value *string
+	}{
+		{"GIK_KUBERNETES_VERSION", &cfg.KubeVersion},
+		{"GIK_CONTAINERD_VERSION", &cfg.ContainerdVersion},
+		{"GIK_CALICO_VERSION", &cfg.CalicoVersion},
+		{"GIK_KUBECTL_TIMEOUT", &cfg.KubectlTimeout},
+		{"GIK_POD_SUBNET", &cfg.PodSubnet},
+		{"GIK_SERVICE_SUBNET", &cfg.ServiceSubnet},
+		{"GIK_CLUSTER_DOMAIN", &cfg.ClusterDomain},
+		{"GIK_JOIN_TOKEN_TTL", &cfg.JoinTokenTTL},
+		{"GIK_CONTROL_PLANE_ENDPOINT", &cfg.ControlPlaneEndpoint},
+		{"GIK_KUBE_VIP", &cfg.KubeVIP},
+		{"GIK_KUBE_VIP_INTERFACE", &cfg.KubeVIPInterface},
+		{"GIK_KUBE_VIP_VERSION", &cfg.KubeVIPVersion},
+		{"GIK_CNI", &cfg.CNI},
+		{"GIK_FLANNEL_VERSION", &cfg.FlannelVersion},
+		{"GIK_CILIUM_VERSION", &cfg.CiliumVersion},
+		{"GIK_LOCAL_PATH_DIR", &cfg.LocalPathDir},
+		{"GIK_IMAGE_REPOSITORY", &cfg.ImageRepository},
+		{"GIK_CONTAINERD_SOURCE", &cfg.ContainerdSource},
+		{"GIK_RUNC_VERSION", &cfg.RuncVersion},
+		{"GIK_CNI_PLUGINS_VERSION", &cfg.CNIPluginsVersion},
+		{"GIK_KUBECONFIG_USER", &cfg.KubeconfigUser},
+	}
+
+	for _, v := range vars {
+		if value, ok := os.LookupEnv(v.name); ok && value != "" {
+			*v.value = value
+		}
+	}
+
+	if value, ok := os.LookupEnv("GIK_ADDONS"); ok && value != "" {
+		cfg.Addons = SplitList(value)
+	}
+}
+
+// SplitList splits a comma separated list, dropping empty entries.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks that the settings are well formed.
+func (c *Config) Validate() error {
+	versions := []struct {
+		name    string
+		value   string
+		pattern *regexp.Regexp
+	}{
+		{"kubernetesVersion", c.KubeVersion, patchVersionPattern},
+		{"containerdVersion", c.ContainerdVersion, patchVersionPattern},
+		{"calicoVersion", c.CalicoVersion, patchVersionPattern},
+		{"kubeVIPVersion", c.KubeVIPVersion, patchVersionPattern},
+		{"flannelVersion", c.FlannelVersion, patchVersionPattern},
+		{"ciliumVersion", c.CiliumVersion, patchVersionPattern},
+		{"runcVersion", c.RuncVersion, patchVersionPattern},
+		{"cniPluginsVersion", c.CNIPluginsVersion, patchVersionPattern},
+	}
+
+	for _, v := range versions {
+		if !v.pattern.MatchString(v.value) {
+			return fmt.Errorf("invalid %s %q", v.name, v.value)
+		}
+	}
+
+	if _, err := time.ParseDuration(c.KubectlTimeout); err != nil {
+		return fmt.Errorf("invalid kubectlTimeout %q: %v", c.KubectlTimeout, err)
+	}
+
+	// A TTL of 0 means the token never expires, which kubeadm allows
+	if _, err := time.ParseDuration(c.JoinTokenTTL); err != nil {
+		return fmt.Errorf("invalid joinTokenTTL %q: %v", c.JoinTokenTTL, err)
+	}
+
+	if c.TigeraOperatorVersion() == "" {
+		return fmt.Errorf("unsupported calicoVersion %q, expected one of %s", c.CalicoVersion, strings.Join(calicoReleases(), ", "))
+	}
+
+	// The Flannel and Cilium manifests are embedded for a single release,
+	// whose objects and flags a different release may not accept
+	manifestVersions := []struct {
+		name     string
+		value    string
+		manifest string
+	}{
+		{"flannelVersion", c.FlannelVersion, DefaultFlannelVersion},
+		{"ciliumVersion", c.CiliumVersion, DefaultCiliumVersion},
+	}
+	for _, v := range manifestVersions {
+		if v.value != v.manifest {
+			return fmt.Errorf("unsupported %s %q, the embedded manifest is for %s", v.name, v.value, v.manifest)
+		}
+	}
+
+	if !slices.Contains(CNIs, c.CNI) {
+		return fmt.Errorf("invalid cni %q, expected one of %s", c.CNI, strings.Join(CNIs, ", "))
+	}
+
+	if !slices.Contains(ContainerdSources, c.ContainerdSource) {
+		return fmt.Errorf("invalid containerdSource %q, expected one of %s", c.ContainerdSource, strings.Join(ContainerdSources, ", "))
+	}
+
+	_, podNet, err := net.ParseCIDR(c.PodSubnet)
+	if err != nil {
+		return fmt.Errorf("invalid podSubnet %q: %v", c.PodSubnet, err)
+	}
+	_, serviceNet, err := net.ParseCIDR(c.ServiceSubnet)
+	if err != nil {
+		return fmt.Errorf("invalid serviceSubnet %q: %v", c.ServiceSubnet, err)
+	}
+
+	// Calico hands out /26 blocks from the pod subnet, the others a /24
+	// per node
+	maxPrefix := 24
+	if c.CNI == CNICalico {
+		maxPrefix = 26
+	}
+	if ones, _ := podNet.Mask.Size(); ones > maxPrefix {
+		return fmt.Errorf("podSubnet %s is too small for %s, it must be /%d or larger", c.PodSubnet, c.CNI, maxPrefix)
+	}
+	if Overlaps(podNet, serviceNet) {
+		return fmt.Errorf("podSubnet %s overlaps serviceSubnet %s", c.PodSubnet, c.ServiceSubnet)
+	}
+
+	if !domainPattern.MatchString(c.ClusterDomain) {
+		return fmt.Errorf("invalid clusterDomain %q", c.ClusterDomain)
+	}
+
+	if c.ControlPlaneEndpoint != "" {
+		host := c.ControlPlaneEndpoint
+		if h, port, err := net.SplitHostPort(c.ControlPlaneEndpoint); err == nil {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return fmt.Errorf("invalid controlPlaneEndpoint port %q", port)
+			}
+			host = h
+		}
+		if net.ParseIP(host) == nil && !domainPattern.MatchString(host) {
+			return fmt.Errorf("invalid controlPlaneEndpoint %q, expected a DNS name or IP address", c.ControlPlaneEndpoint)
+		}
+		// A DNS name may resolve to the VIP, but a different IP can't be right
+		if c.KubeVIP != "" && net.ParseIP(host) != nil && host != c.KubeVIP {
+			return fmt.Errorf("controlPlaneEndpoint %q doesn't match kubeVIP %q", c.ControlPlaneEndpoint, c.KubeVIP)
+		}
+	}
+
+	if c.KubeVIP != "" && net.ParseIP(c.KubeVIP) == nil {
+		return fmt.Errorf("invalid kubeVIP %q, expected an IP address", c.KubeVIP)
+	}
+	if c.KubeVIPInterface != "" && !interfacePattern.MatchString(c.KubeVIPInterface) {
+		return fmt.Errorf("invalid kubeVIPInterface %q", c.KubeVIPInterface)
+	}
+
+	// The path is written into JSON and handed to a shell by the provisioner
+	if !hostPathPattern.MatchString(c.LocalPathDir) || filepath.Clean(c.LocalPathDir) != c.LocalPathDir {
+		return fmt.Errorf("invalid localPathDir %q, expected an absolute path", c.LocalPathDir)
+	}
+
+	if c.KubeconfigUser != "" && !userPattern.MatchString(c.KubeconfigUser) {
+		return fmt.Errorf("invalid kubeconfigUser %q", c.KubeconfigUser)
+	}
+
+	if c.ImageRepository != "" && !repositoryPattern.MatchString(c.ImageRepository) {
+		return fmt.Errorf("invalid imageRepository %q, expected a registry host and path such as registry.internal/k8s", c.ImageRepository)
+	}
+	if err := validateRegistries(c.Registries); err != nil {
+		return err
+	}
+	return validateContainerd(c.Containerd)
+}
+
+// validateContainerd checks the containerd options, which are written to
+// config.toml.
+func validateContainerd(opts ContainerdOptions) error {
+	if opts.SandboxImage != "" && !imagePattern.MatchString(opts.SandboxImage) {
+		return fmt.Errorf("invalid containerd sandboxImage %q", opts.SandboxImage)
+	}
+	if !hostPathPattern.MatchString(opts.Root) || filepath.Clean(opts.Root) != opts.Root {
+		return fmt.Errorf("invalid containerd root %q, expected an absolute path", opts.Root)
+	}
+	if !nameLabelPattern.MatchString(opts.Snapshotter) {
+		return fmt.Errorf("invalid containerd snapshotter %q", opts.Snapshotter)
+	}
+
+	seen := map[string]bool{}
+	for _, rt := range opts.Runtimes {
+		// A RuntimeClass handler must be a DNS label
+		if !nameLabelPattern.MatchString(rt.Name) || len(rt.Name) > 63 {
+			return fmt.Errorf("invalid containerd runtime name %q, expected a DNS label", rt.Name)
+		}
+		if rt.Name == "runc" {
+			return fmt.Errorf("containerd runtime name runc is taken by the default runtime")
+		}
+		if seen[rt.Name] {
+			return fmt.Errorf("containerd runtime %s is configured twice", rt.Name)
+		}
+		seen[rt.Name] = true
+
+		if rt.Type != "" && !runtimeTypePattern.MatchString(rt.Type) {
+			return fmt.Errorf("invalid type %q for containerd runtime %s", rt.Type, rt.Name)
+		}
+		if rt.BinaryName != "" {
+			if rt.Type != "" && rt.Type != DefaultRuntimeType {
+				return fmt.Errorf("containerd runtime %s sets binaryName, which only %s takes", rt.Name, DefaultRuntimeType)
+			}
+			if !binaryPattern.MatchString(rt.BinaryName) {
+				return fmt.Errorf("invalid binaryName %q for containerd runtime %s", rt.BinaryName, rt.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRegistries checks the registry entries, which end up in TOML files
+// for containerd, so their values are kept to what a URL or path needs.
+func validateRegistries(registries []Registry) error {
+	seen := map[string]bool{}
+	for _, reg := range registries {
+		if !registryHostPattern.MatchString(reg.Host) {
+			return fmt.Errorf("invalid registry host %q, expected a host name with an optional port", reg.Host)
+		}
+		if seen[reg.Host] {
+			return fmt.Errorf("registry %s is configured twice", reg.Host)
+		}
+		seen[reg.Host] = true
+
+		urls := reg.Mirrors
+		if reg.Server != "" {
+			urls = append([]string{reg.Server}, urls...)
+		}
+		for _, value := range urls {
+			u, err := url.Parse(value)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || !registryHostPattern.MatchString(u.Host) ||
+				u.User != nil || u.RawQuery != "" || u.Fragment != "" || strings.ContainsAny(value, "\"\\ ") {
+				return fmt.Errorf("invalid URL %q for registry %s, expected http:// or https:// and a host", value, reg.Host)
+			}
+		}
+
+		if reg.CAFile != "" && (!hostPathPattern.MatchString(reg.CAFile) || filepath.Clean(reg.CAFile) != reg.CAFile) {
+			return fmt.Errorf("invalid caFile %q for registry %s, expected an absolute path", reg.CAFile, reg.Host)
+		}
+
+		if (reg.Username == "") != (reg.Password == "") {
+			return fmt.Errorf("registry %s needs both a username and a password", reg.Host)
+		}
+		if strings.ContainsFunc(reg.Username+reg.Password, unicode.IsControl) {
+			return fmt.Errorf("registry %s credentials contain control characters", reg.Host)
+		}
+	}
+	return nil
+}
+
+// TigeraOperatorVersion returns the Tigera operator release that installs
+// the configured Calico release, or "" if it isn't one the embedded
+// operator manifest works with.
+func (c *Config) TigeraOperatorVersion() string {
+	return tigeraOperatorVersions[c.CalicoVersion]
+}
+
+// calicoReleases lists the supported calicoVersion values in order.
+func calicoReleases() []string {
+	var releases []string
+	for release := range tigeraOperatorVersions {
+		releases = append(releases, release)
+	}
+	slices.Sort(releases)
+	return releases
+}
+
+// HighlyAvailable reports whether the control plane has a shared endpoint,
+// so that more control plane nodes can join.
+func (c *Config) HighlyAvailable() bool {
+	return c.ControlPlaneEndpoint != "" || c.KubeVIP != ""
+}
+
+// APIServerEndpoint returns the HA control plane endpoint as host:port,
+// defaulting to the kube-vip address and the API server port.
+func (c *Config) APIServerEndpoint() string {
+	endpoint := c.ControlPlaneEndpoint
+	if endpoint == "" {
+		endpoint = c.KubeVIP
+	}
+	if _, _, err := net.SplitHostPort(endpoint); err == nil {
+		return endpoint
+	}
+	return net.JoinHostPort(endpoint, "6443")
+}
+
+// APIServerPort returns the port of APIServerEndpoint.
+func (c *Config) APIServerPort() string {
+	_, port, _ := net.SplitHostPort(c.APIServerEndpoint())
+	return port
+}
+
+// KubeVIPCIDR returns the prefix length kube-vip adds the VIP with, a
+// single address of its family.
+func (c *Config) KubeVIPCIDR() string {
+	if ip := net.ParseIP(c.KubeVIP); ip != nil && ip.To4() == nil {
+		return "128"
+	}
+	return "32"
+}
+
+// Overlaps reports whether two networks share any addresses.
+func Overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	tokenPattern   = regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`)
+	caHashPattern  = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	certKeyPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// LoadJoinCommand fills in the join settings from a file holding the output
+// of "kubeadm token create --print-join-command". The command is parsed
+// rather than run, so the file can't smuggle in anything else.
+func LoadJoinCommand(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read join command file: %v", err)
+	}
+	if err := ParseJoinCommand(string(content), cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// ParseJoinCommand fills in the join settings from a kubeadm join command.
+func ParseJoinCommand(cmd string, cfg *Config) error {
+	fields := strings.Fields(cmd)
+	if len(fields) < 3 || fields[0] != "kubeadm" || fields[1] != "join" {
+		return fmt.Errorf("not a kubeadm join command")
+	}
+
+	cfg.JoinEndpoint = fields[2]
+	for i := 3; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "--token":
+			cfg.JoinToken = fields[i+1]
+		case "--discovery-token-ca-cert-hash":
+			cfg.JoinCAHash = fields[i+1]
+		case "--certificate-key":
+			cfg.CertificateKey = fields[i+1]
+		}
+	}
+	return nil
+}
+
+// ValidateJoin checks the join settings, if any were given.
+func (c *Config) ValidateJoin() error {
+	if c.JoinEndpoint == "" && c.JoinToken == "" && c.JoinCAHash == "" && c.JoinServer == "" {
+		if c.JoinControlPlane {
+			return fmt.Errorf("--join-control-plane needs join settings, e.g. --join-endpoint or --join-server")
+		}
+		return nil
+	}
+
+	if c.IsSingleNode || (c.IsControlNode && !c.JoinControlPlane) {
+		return fmt.Errorf("joining a cluster is only supported for worker nodes (-w) or with --join-control-plane")
+	}
+
+	// The join details are fetched later, just before they are used
+	if c.JoinServer != "" {
+		if c.JoinEndpoint != "" || c.JoinToken != "" || c.JoinCAHash != "" {
+			return fmt.Errorf("--join-server can't be combined with other join settings")
+		}
+		if !strings.HasPrefix(c.JoinServer, "https://") {
+			return fmt.Errorf("invalid join server %q, expected an https:// URL", c.JoinServer)
+		}
+		if c.JoinPSK == "" {
+			return fmt.Errorf("--join-server needs a pre-shared key, set --psk or GIK_JOIN_PSK")
+		}
+		return nil
+	}
+
+	if c.JoinControlPlane && !certKeyPattern.MatchString(c.CertificateKey) {
+		return fmt.Errorf("--join-control-plane needs the --certificate-key printed by the first control plane node")
+	}
+	return ValidateJoinDetails(c.JoinEndpoint, c.JoinToken, c.JoinCAHash)
+}
+
+// ValidateCertificateKey checks a key for decrypting the uploaded control
+// plane certificates.
+func ValidateCertificateKey(key string) error {
+	if !certKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid certificate key, expected 64 hex characters")
+	}
+	return nil
+}
+
+// ValidateJoinDetails checks the three things kubeadm join needs.
+func ValidateJoinDetails(endpoint, token, caHash string) error {
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		return fmt.Errorf("invalid join endpoint %q, expected host:port", endpoint)
+	}
+	if !tokenPattern.MatchString(token) {
+		return fmt.Errorf("invalid join token, expected the form abcdef.0123456789abcdef")
+	}
+	if !caHashPattern.MatchString(caHash) {
+		return fmt.Errorf("invalid discovery token CA cert hash %q, expected sha256:<hex>", caHash)
+	}
+	return nil
+}
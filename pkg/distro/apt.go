@@ -0,0 +1,232 @@
+package distro
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+const (
+	aptKubernetesList    = "/etc/apt/sources.list.d/kubernetes.list"
+	aptKubernetesKeyring = "/etc/apt/keyrings/kubernetes-apt-keyring.gpg"
+	aptLocalList         = "/etc/apt/sources.list.d/" + localRepo + ".list"
+)
+
+// apt covers Ubuntu and Debian, which ship containerd themselves.
+type apt struct {
+	name string
+
+	// Set by Offline to the host paths of the local repository and the
+	// sources list naming it
+	repoDir    string
+	sourceList string
+}
+
+func newApt(name string) Distro {
+	return apt{name: name}
+}
+
+func (a apt) Name() string {
+	return a.name
+}
+
+func (a apt) Refresh(r exec.Runner) error {
+	// The other sources can't be reached, and a failed update is an error
+	if a.repoDir != "" {
+		return run(r, fmt.Sprintf("apt-get update -o Dir::Etc::SourceList=%s -o Dir::Etc::SourceParts=- -o APT::Get::List-Cleanup=0", a.sourceList))
+	}
+	return run(r, "apt-get update")
+}
+
+func (a apt) Install(r exec.Runner, pkgs ...string) error {
+	cmd := "apt-get install -y"
+	// A pinned version may be older than the one installed
+	if slices.ContainsFunc(pkgs, func(pkg string) bool { return strings.Contains(pkg, "=") }) {
+		cmd += " --allow-downgrades"
+	}
+	return run(r, fmt.Sprintf("%s %s", cmd, strings.Join(pkgs, " ")))
+}
+
+func (a apt) Remove(r exec.Runner, pkgs ...string) error {
+	return run(r, fmt.Sprintf("apt-get remove -y %s", strings.Join(pkgs, " ")))
+}
+
+func (a apt) Purge(r exec.Runner, pkgs ...string) error {
+	return run(r, fmt.Sprintf("apt-get purge -y %s", strings.Join(pkgs, " ")))
+}
+
+func (a apt) Autoremove(r exec.Runner) error {
+	return run(r, "apt-get autoremove -y")
+}
+
+func (a apt) Hold(r exec.Runner, pkgs ...string) error {
+	return run(r, fmt.Sprintf("apt-mark hold %s", strings.Join(pkgs, " ")))
+}
+
+func (a apt) Unhold(r exec.Runner, pkgs ...string) error {
+	return run(r, fmt.Sprintf("apt-mark unhold %s", strings.Join(pkgs, " ")))
+}
+
+func (a apt) Pin(pkg, version string) string {
+	if strings.Count(version, ".") == 2 {
+		return fmt.Sprintf("%s=%s-*", pkg, version)
+	}
+	return fmt.Sprintf("%s=%s.*", pkg, version)
+}
+
+func (a apt) BasePackages() []string {
+	return []string{
+		"apt-transport-https",
+		"ca-certificates",
+		"curl",
+		"gnupg",
+		"lsb-release",
+		"software-properties-common",
+		"wget",
+		"jq",
+	}
+}
+
+// ConflictingPackages are the Moby packages from Microsoft's repository.
+// Removing containerd takes docker.io with it.
+func (a apt) ConflictingPackages() []string {
+	return []string{"moby-buildx", "moby-cli", "moby-compose", "moby-containerd", "moby-engine", "moby-runc"}
+}
+
+func (a apt) ContainerdPackage() string {
+	return "containerd"
+}
+
+func (a apt) AddContainerdRepo(r exec.Runner, fsys hostfs.FS) error {
+	if a.repoDir != "" {
+		return a.addLocalRepo(fsys)
+	}
+	return nil
+}
+
+func (a apt) AddKubernetesRepo(r exec.Runner, fsys hostfs.FS, version string) error {
+	if a.repoDir != "" {
+		return a.addLocalRepo(fsys)
+	}
+
+	kubeRepoVersion := minorVersion(version)
+
+	// Remove old repo file and GPG key if they exist
+	fsys.Remove(aptKubernetesList)
+	fsys.Remove(aptKubernetesKeyring)
+
+	// Create keyrings directory if it doesn't exist
+	if err := fsys.MkdirAll(filepath.Dir(aptKubernetesKeyring), 0755); err != nil {
+		return err
+	}
+
+	// Download and install GPG key
+	gpgKeyURL := fmt.Sprintf("https://pkgs.k8s.io/core:/stable:/v%s/deb/Release.key", kubeRepoVersion)
+
+	// Create secure temporary directory
+	tmpDir, err := fsys.MkdirTemp("k8s-gpg-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer fsys.RemoveAll(tmpDir)
+
+	// Set secure permissions
+	if err := fsys.Chmod(tmpDir, 0700); err != nil {
+		return fmt.Errorf("failed to set permissions on temp directory: %v", err)
+	}
+
+	keyPath := filepath.Join(tmpDir, "k8s-key.gpg")
+	if _, err := r.Run(fmt.Sprintf("curl -fsSLo %s %s", fsys.Path(keyPath), gpgKeyURL)); err != nil {
+		return err
+	}
+
+	if _, err := r.Run(fmt.Sprintf("gpg --dearmor --yes -o %s %s", fsys.Path(aptKubernetesKeyring), fsys.Path(keyPath))); err != nil {
+		return err
+	}
+
+	// Clean up temp file
+	fsys.Remove(keyPath)
+
+	// Add new repo
+	repoContent := fmt.Sprintf("deb [signed-by=%s] https://pkgs.k8s.io/core:/stable:/v%s/deb/ /", aptKubernetesKeyring, kubeRepoVersion)
+	return fsys.WriteFile(aptKubernetesList, []byte(repoContent), 0644)
+}
+
+// addLocalRepo writes a flat repository entry for the bundle. It isn't
+// signed, the bundle as a whole is what the user trusts.
+func (a apt) addLocalRepo(fsys hostfs.FS) error {
+	repoContent := fmt.Sprintf("deb [trusted=yes] file:%s ./\n", a.repoDir)
+	return fsys.WriteFile(aptLocalList, []byte(repoContent), 0644)
+}
+
+func (a apt) RepoFiles() []string {
+	return []string{aptKubernetesList, aptKubernetesKeyring, aptLocalList}
+}
+
+func (a apt) KubeletEnvFile() string {
+	return "/etc/default/kubelet"
+}
+
+func (a apt) ConfigureSystem(r exec.Runner, fsys hostfs.FS, opts SystemOptions) error {
+	return nil
+}
+
+func (a apt) BundleTools() []string {
+	return []string{"apt-utils", "zstd"}
+}
+
+// DownloadPackages resolves pkgs against an empty package database, so that
+// apt fetches the whole dependency tree rather than what this host lacks.
+func (a apt) DownloadPackages(r exec.Runner, fsys hostfs.FS, dir string, pkgs ...string) error {
+	if err := fsys.MkdirAll(filepath.Join(dir, "partial"), 0755); err != nil {
+		return err
+	}
+	status := filepath.Join(dir, "status")
+	if err := fsys.WriteFile(status, nil, 0644); err != nil {
+		return err
+	}
+
+	err := run(r, fmt.Sprintf("apt-get install -y --download-only -o Dir::Cache::Archives=%s -o Dir::State::Status=%s %s",
+		fsys.Path(dir), fsys.Path(status), strings.Join(pkgs, " ")))
+	if err != nil {
+		return err
+	}
+
+	// Leave only the packages behind
+	for _, name := range []string{"partial", "status", "lock"} {
+		if err := fsys.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexPackages makes dir a flat repository. apt-ftparchive names each
+// package by the path it was given, which is made relative to dir so the
+// repository can be moved.
+func (a apt) IndexPackages(r exec.Runner, fsys hostfs.FS, dir string) error {
+	res, err := r.Run(fmt.Sprintf("apt-ftparchive packages %s", fsys.Path(dir)))
+	if err != nil {
+		return err
+	}
+	packages := strings.ReplaceAll(res.Stdout, "Filename: "+fsys.Path(dir)+"/", "Filename: ./")
+	if err := fsys.WriteFile(filepath.Join(dir, "Packages"), []byte(packages), 0644); err != nil {
+		return err
+	}
+
+	res, err = r.Run(fmt.Sprintf("apt-ftparchive release %s", fsys.Path(dir)))
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Join(dir, "Release"), []byte(res.Stdout), 0644)
+}
+
+func (a apt) Offline(fsys hostfs.FS, dir string) Distro {
+	a.repoDir = fsys.Path(dir)
+	a.sourceList = fsys.Path(aptLocalList)
+	return a
+}
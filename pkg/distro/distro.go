@@ -0,0 +1,242 @@
+// Package distro hides the differences between the operating systems the
+// installer supports, chiefly how packages and repositories are managed.
+package distro
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// Distro manages packages and repositories the way one operating system
+// expects. Package names are passed through as is, except for Pin.
+type Distro interface {
+	// Name is the release the host runs, such as "Ubuntu 24.04".
+	Name() string
+
+	// Refresh updates the package metadata from the configured repositories.
+	Refresh(r exec.Runner) error
+	Install(r exec.Runner, pkgs ...string) error
+	Remove(r exec.Runner, pkgs ...string) error
+	// Purge removes the packages along with their configuration, where the
+	// package manager keeps it.
+	Purge(r exec.Runner, pkgs ...string) error
+	Autoremove(r exec.Runner) error
+
+	// Hold stops the packages from changing version on a system upgrade.
+	Hold(r exec.Runner, pkgs ...string) error
+	Unhold(r exec.Runner, pkgs ...string) error
+
+	// Pin names a package at version, either a full release such as 1.31.5
+	// or a minor release such as 1.32 for its latest patch.
+	Pin(pkg, version string) string
+
+	// BasePackages are the tools the install steps rely on.
+	BasePackages() []string
+	// ConflictingPackages are container runtimes that get in the way of
+	// the containerd package.
+	ConflictingPackages() []string
+	ContainerdPackage() string
+
+	// AddContainerdRepo sets up the repository ContainerdPackage comes from,
+	// if the distribution doesn't ship it.
+	AddContainerdRepo(r exec.Runner, fsys hostfs.FS) error
+	// AddKubernetesRepo points the package manager at the pkgs.k8s.io
+	// repository for the minor release of version. Each minor release has
+	// its own repository.
+	AddKubernetesRepo(r exec.Runner, fsys hostfs.FS, version string) error
+	// RepoFiles are the files written by the Add*Repo methods.
+	RepoFiles() []string
+
+	// KubeletEnvFile is where the kubelet unit reads KUBELET_EXTRA_ARGS.
+	KubeletEnvFile() string
+
+	// ConfigureSystem makes any changes kubeadm needs beyond the kernel
+	// modules and sysctls every distribution gets. Reset leaves them in
+	// place, since how the host was set up before isn't recorded.
+	ConfigureSystem(r exec.Runner, fsys hostfs.FS, opts SystemOptions) error
+
+	// BundleTools are the packages DownloadPackages and IndexPackages need.
+	BundleTools() []string
+	// DownloadPackages fetches pkgs and everything they depend on into dir,
+	// including what is already installed here, since the host they are
+	// for may have less.
+	DownloadPackages(r exec.Runner, fsys hostfs.FS, dir string, pkgs ...string) error
+	// IndexPackages writes the repository metadata for the packages in dir.
+	IndexPackages(r exec.Runner, fsys hostfs.FS, dir string) error
+	// Offline returns a Distro that installs only from the repository
+	// IndexPackages made in dir, for a host without network access. Its
+	// Add*Repo methods point the package manager at dir instead.
+	Offline(fsys hostfs.FS, dir string) Distro
+}
+
+// SystemOptions say how far ConfigureSystem may change the host's security
+// settings.
+type SystemOptions struct {
+	// SELinuxPermissive allows SELinux to be switched to permissive mode.
+	// Without it an enforcing host is only warned about.
+	SELinuxPermissive bool
+	// FirewallPorts are opened in the host firewall, as port/protocol or
+	// low-high/protocol.
+	FirewallPorts []string
+	// TrustedSources are networks, such as the pod subnet, whose traffic
+	// the firewall lets through.
+	TrustedSources []string
+}
+
+// localRepo names the repository Offline installs from.
+const localRepo = "go-install-kubernetes-bundle"
+
+// Release is the identification from an os-release file.
+type Release struct {
+	ID         string
+	IDLike     []string
+	VersionID  string
+	PrettyName string
+}
+
+func (rel Release) String() string {
+	if rel.PrettyName != "" {
+		return rel.PrettyName
+	}
+	return strings.TrimSpace(rel.ID + " " + rel.VersionID)
+}
+
+// Releases the installer supports. A version without a dot also matches its
+// point releases, so "9" covers 9.4.
+var releases = []supportedRelease{
+	{"ubuntu", "22.04", "Ubuntu 22.04", newApt},
+	{"ubuntu", "24.04", "Ubuntu 24.04", newApt},
+	{"debian", "12", "Debian 12", newApt},
+	{"rocky", "9", "Rocky Linux 9", newDNF},
+	{"almalinux", "9", "AlmaLinux 9", newDNF},
+	{"rhel", "9", "RHEL 9", newDNF},
+}
+
+type supportedRelease struct {
+	id      string
+	version string
+	name    string
+	family  func(name string) Distro
+}
+
+// matches reports whether versionID is this release or, for a version
+// without a dot, one of its point releases.
+func (s supportedRelease) matches(versionID string) bool {
+	return versionID == s.version ||
+		!strings.Contains(s.version, ".") && strings.HasPrefix(versionID, s.version+".")
+}
+
+// Supported lists the names of the supported releases.
+func Supported() []string {
+	var names []string
+	for _, release := range releases {
+		names = append(names, release.name)
+	}
+	return names
+}
+
+// Detect reads the host's os-release file and returns its Distro.
+func Detect(fsys hostfs.FS) (Distro, error) {
+	content, err := fsys.ReadFile("/etc/os-release")
+	if errors.Is(err, fs.ErrNotExist) {
+		content, err = fsys.ReadFile("/usr/lib/os-release")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify the operating system: %v", err)
+	}
+	return Lookup(ParseOSRelease(content))
+}
+
+// Named returns the Distro for a release given as ID-VERSION_ID, such as
+// ubuntu-24.04, for planning against a root without an os-release file.
+func Named(name string) (Distro, error) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 || i == len(name)-1 {
+		return nil, fmt.Errorf("invalid release %q, expected an ID and version such as ubuntu-24.04", name)
+	}
+	return Lookup(Release{ID: strings.ToLower(name[:i]), VersionID: name[i+1:]})
+}
+
+// Lookup returns the Distro for a release, or an error naming the supported
+// releases if it isn't one of them. A release the installer doesn't know is
+// treated like the first supported one its ID_LIKE names at the same
+// version, so rebuilds such as CentOS Stream 9 work.
+func Lookup(rel Release) (Distro, error) {
+	for _, release := range releases {
+		if rel.ID == release.id && release.matches(rel.VersionID) {
+			return release.family(release.name), nil
+		}
+	}
+	for _, like := range rel.IDLike {
+		for _, release := range releases {
+			if like == release.id && release.matches(rel.VersionID) {
+				return release.family(rel.String()), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not supported, use one of %s", rel, strings.Join(Supported(), ", "))
+}
+
+// ParseOSRelease reads the fields of an os-release file. Values may be
+// quoted, and lines that aren't assignments are ignored.
+func ParseOSRelease(content []byte) Release {
+	var rel Release
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		value = unquote(value)
+
+		switch key {
+		case "ID":
+			rel.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			rel.IDLike = strings.Fields(strings.ToLower(value))
+		case "VERSION_ID":
+			rel.VersionID = value
+		case "PRETTY_NAME":
+			rel.PrettyName = value
+		}
+	}
+	return rel
+}
+
+// unquote strips shell quoting from an os-release value. Only the escapes
+// the os-release format allows inside double quotes are handled.
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return value[1 : len(value)-1]
+		case value[0] == '"' && value[len(value)-1] == '"':
+			value = value[1 : len(value)-1]
+			return strings.NewReplacer(`\"`, `"`, `\\`, `\`, "\\`", "`", `\$`, `$`).Replace(value)
+		}
+	}
+	return value
+}
+
+// run runs each command in turn, stopping at the first failure.
+func run(r exec.Runner, cmds ...string) error {
+	for _, cmd := range cmds {
+		if _, err := r.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minorVersion returns the minor release of version, 1.29 from 1.29.0.
+func minorVersion(version string) string {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return version
+	}
+	return strings.Join(parts[:2], ".")
+}
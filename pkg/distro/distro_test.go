@@ -0,0 +1,248 @@
+package distro
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// rootWith returns a host root with testdata/name as its os-release file at
+// path.
+func rootWith(t *testing.T, name, path string) hostfs.FS {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, path), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return hostfs.New(root)
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		osRelease string
+		name      string
+		apt       bool
+	}{
+		{"ubuntu-24.04", "Ubuntu 24.04", true},
+		{"debian-12", "Debian 12", true},
+		{"rocky-9.4", "Rocky Linux 9", false},
+		// Unknown IDs are installed like the release ID_LIKE names
+		{"centos-9", "CentOS Stream 9", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.osRelease, func(t *testing.T) {
+			d, err := Detect(rootWith(t, tt.osRelease, "etc/os-release"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Name() != tt.name {
+				t.Errorf("got %s, want %s", d.Name(), tt.name)
+			}
+			if _, isApt := d.(apt); isApt != tt.apt {
+				t.Errorf("got %T for %s", d, tt.name)
+			}
+		})
+	}
+}
+
+func TestDetectUsrLib(t *testing.T) {
+	d, err := Detect(rootWith(t, "debian-12", "usr/lib/os-release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name() != "Debian 12" {
+		t.Errorf("got %s, want Debian 12", d.Name())
+	}
+}
+
+func TestDetectUnsupported(t *testing.T) {
+	tests := []struct {
+		osRelease string
+		want      string
+	}{
+		{"ubuntu-20.04", "Ubuntu 20.04.6 LTS is not supported"},
+		// ID_LIKE names Ubuntu, but not at a supported version
+		{"linuxmint-21.3", "Linux Mint 21.3 is not supported"},
+		// ID_LIKE is only fedora
+		{"ol-9.4", "Oracle Linux Server 9.4 is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.osRelease, func(t *testing.T) {
+			_, err := Detect(rootWith(t, tt.osRelease, "etc/os-release"))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+			if !strings.Contains(err.Error(), strings.Join(Supported(), ", ")) {
+				t.Errorf("error doesn't list the supported releases: %v", err)
+			}
+		})
+	}
+
+	if _, err := Detect(hostfs.New(t.TempDir())); err == nil || !strings.HasPrefix(err.Error(), "failed to identify the operating system") {
+		t.Errorf("expected a missing os-release error, got %v", err)
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	content := `# a comment
+ID="RHEL"
+ID_LIKE='Fedora  centos'
+VERSION_ID=9.4
+PRETTY_NAME="Red Hat \"Enterprise\" Linux \$9 \\ \` + "`" + `x\` + "`" + `"
+not an assignment
+NAME=
+`
+	want := Release{
+		ID:         "rhel",
+		IDLike:     []string{"fedora", "centos"},
+		VersionID:  "9.4",
+		PrettyName: `Red Hat "Enterprise" Linux $9 \ ` + "`x`",
+	}
+	got := ParseOSRelease([]byte(content))
+	if got.ID != want.ID || !slices.Equal(got.IDLike, want.IDLike) || got.VersionID != want.VersionID || got.PrettyName != want.PrettyName {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		rel  Release
+		name string
+	}{
+		{Release{ID: "ubuntu", VersionID: "22.04"}, "Ubuntu 22.04"},
+		{Release{ID: "rhel", VersionID: "9"}, "RHEL 9"},
+		{Release{ID: "almalinux", VersionID: "9.5"}, "AlmaLinux 9"},
+		// An exact ID match wins over ID_LIKE
+		{Release{ID: "rocky", IDLike: []string{"rhel"}, VersionID: "9.4", PrettyName: "Rocky"}, "Rocky Linux 9"},
+		{Release{ID: "pop", IDLike: []string{"ubuntu", "debian"}, VersionID: "22.04"}, "pop 22.04"},
+	}
+	for _, tt := range tests {
+		d, err := Lookup(tt.rel)
+		if err != nil {
+			t.Errorf("Lookup(%+v): %v", tt.rel, err)
+			continue
+		}
+		if d.Name() != tt.name {
+			t.Errorf("Lookup(%+v) = %s, want %s", tt.rel, d.Name(), tt.name)
+		}
+	}
+
+	// A dotted version only matches itself
+	for _, rel := range []Release{{ID: "ubuntu", VersionID: "24.04.1"}, {ID: "ubuntu", VersionID: "24"}, {ID: "rocky", VersionID: "90"}} {
+		if _, err := Lookup(rel); err == nil {
+			t.Errorf("Lookup(%+v) succeeded", rel)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	for name, want := range map[string]string{
+		"ubuntu-24.04": "Ubuntu 24.04",
+		"Rocky-9.4":    "Rocky Linux 9",
+		"debian-12":    "Debian 12",
+	} {
+		d, err := Named(name)
+		if err != nil {
+			t.Errorf("Named(%s): %v", name, err)
+			continue
+		}
+		if d.Name() != want {
+			t.Errorf("Named(%s) = %s, want %s", name, d.Name(), want)
+		}
+	}
+
+	for _, name := range []string{"ubuntu", "-24.04", "ubuntu-", "fedora-40"} {
+		if _, err := Named(name); err == nil {
+			t.Errorf("Named(%s) succeeded", name)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	tests := []struct {
+		d       Distro
+		version string
+		want    string
+	}{
+		{newApt("Ubuntu 24.04"), "1.31.5", "kubelet=1.31.5-*"},
+		{newApt("Ubuntu 24.04"), "1.32", "kubelet=1.32.*"},
+		{newDNF("Rocky Linux 9"), "1.31.5", "kubelet-1.31.5"},
+		{newDNF("Rocky Linux 9"), "1.32", "kubelet-1.32.*"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.Pin("kubelet", tt.version); got != tt.want {
+			t.Errorf("%s Pin(kubelet, %s) = %s, want %s", tt.d.Name(), tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestDNFConfigureSystem(t *testing.T) {
+	const enforcing = "# SELinux\nSELINUX=enforcing\nSELINUXTYPE=targeted\n"
+	opts := SystemOptions{
+		FirewallPorts:  []string{"6443/tcp", "8472/udp"},
+		TrustedSources: []string{"192.168.0.0/16", "10.96.0.0/12"},
+	}
+	firewall := []string{
+		"systemctl is-active firewalld",
+		"firewall-cmd --permanent --add-port=6443/tcp --add-port=8472/udp",
+		"firewall-cmd --permanent --zone=trusted --add-source=192.168.0.0/16 --add-source=10.96.0.0/12",
+		"firewall-cmd --reload",
+	}
+
+	tests := []struct {
+		name       string
+		permissive bool
+		firewalld  bool
+		selinux    string
+		cmds       []string
+	}{
+		{"permissive", true, true, "# SELinux\nSELINUX=permissive\nSELINUXTYPE=targeted\n", append([]string{"getenforce", "setenforce 0"}, firewall...)},
+		// Without the setting SELinux is only warned about
+		{"enforcing", false, true, enforcing, append([]string{"getenforce"}, firewall...)},
+		{"no firewalld", true, false, "# SELinux\nSELINUX=permissive\nSELINUXTYPE=targeted\n", []string{"getenforce", "setenforce 0", "systemctl is-active firewalld"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			fsys := hostfs.New(root)
+			if err := os.MkdirAll(filepath.Join(root, "etc", "selinux"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.WriteFile(selinuxConfig, []byte(enforcing), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := exec.NewRecorder().On("getenforce", exec.Result{Stdout: "Enforcing\n"})
+			if !tt.firewalld {
+				r.On("systemctl is-active firewalld", exec.Result{Stdout: "inactive\n", ExitCode: 3})
+			}
+
+			opts := opts
+			opts.SELinuxPermissive = tt.permissive
+			if err := newDNF("Rocky Linux 9").ConfigureSystem(r, fsys, opts); err != nil {
+				t.Fatal(err)
+			}
+			content, err := fsys.ReadFile(selinuxConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.selinux {
+				t.Errorf("got %q, want %q", content, tt.selinux)
+			}
+			if err := r.Expect(tt.cmds...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
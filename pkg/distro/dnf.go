@@ -0,0 +1,257 @@
+package distro
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+const (
+	dnfKubernetesRepo = "/etc/yum.repos.d/kubernetes.repo"
+	dnfDockerRepo     = "/etc/yum.repos.d/docker-ce.repo"
+	dnfLocalRepo      = "/etc/yum.repos.d/" + localRepo + ".repo"
+	selinuxConfig     = "/etc/selinux/config"
+)
+
+// dnf covers the RHEL family. containerd isn't part of the distribution, so
+// it comes from Docker's repository as containerd.io, and holds are made with
+// the versionlock plugin.
+type dnf struct {
+	name string
+
+	// Set by Offline to the host path of the local repository
+	repoDir string
+}
+
+func newDNF(name string) Distro {
+	return dnf{name: name}
+}
+
+func (d dnf) Name() string {
+	return d.name
+}
+
+// dnf returns the dnf command line for args. Offline, every other
+// repository is disabled, as dnf loads them all even to remove a package.
+func (d dnf) dnf(args string) string {
+	if d.repoDir != "" {
+		return fmt.Sprintf("dnf --disablerepo=* --enablerepo=%s %s", localRepo, args)
+	}
+	return "dnf " + args
+}
+
+func (d dnf) Refresh(r exec.Runner) error {
+	return run(r, d.dnf("makecache"))
+}
+
+func (d dnf) Install(r exec.Runner, pkgs ...string) error {
+	return run(r, d.dnf(fmt.Sprintf("install -y %s", strings.Join(pkgs, " "))))
+}
+
+func (d dnf) Remove(r exec.Runner, pkgs ...string) error {
+	return run(r, d.dnf(fmt.Sprintf("remove -y %s", strings.Join(pkgs, " "))))
+}
+
+// Purge is the same as Remove, as rpm leaves only changed config files.
+func (d dnf) Purge(r exec.Runner, pkgs ...string) error {
+	return d.Remove(r, pkgs...)
+}
+
+func (d dnf) Autoremove(r exec.Runner) error {
+	return run(r, d.dnf("autoremove -y"))
+}
+
+func (d dnf) Hold(r exec.Runner, pkgs ...string) error {
+	return run(r, d.dnf(fmt.Sprintf("versionlock add %s", strings.Join(pkgs, " "))))
+}
+
+func (d dnf) Unhold(r exec.Runner, pkgs ...string) error {
+	return run(r, d.dnf(fmt.Sprintf("versionlock delete %s", strings.Join(pkgs, " "))))
+}
+
+func (d dnf) Pin(pkg, version string) string {
+	if strings.Count(version, ".") == 2 {
+		return fmt.Sprintf("%s-%s", pkg, version)
+	}
+	return fmt.Sprintf("%s-%s.*", pkg, version)
+}
+
+func (d dnf) BasePackages() []string {
+	return []string{
+		"ca-certificates",
+		"curl",
+		"gnupg2",
+		"iproute-tc",
+		"jq",
+		"python3-dnf-plugin-versionlock",
+		"tar",
+		"wget",
+	}
+}
+
+func (d dnf) ConflictingPackages() []string {
+	return []string{"docker", "docker-client", "docker-common", "docker-engine", "podman-docker"}
+}
+
+func (d dnf) ContainerdPackage() string {
+	return "containerd.io"
+}
+
+func (d dnf) AddContainerdRepo(r exec.Runner, fsys hostfs.FS) error {
+	if d.repoDir != "" {
+		return d.addLocalRepo(fsys)
+	}
+
+	repoContent := `[docker-ce-stable]
+name=Docker CE Stable - $basearch
+baseurl=https://download.docker.com/linux/centos/$releasever/$basearch/stable
+enabled=1
+gpgcheck=1
+gpgkey=https://download.docker.com/linux/centos/gpg
+`
+	return fsys.WriteFile(dnfDockerRepo, []byte(repoContent), 0644)
+}
+
+func (d dnf) AddKubernetesRepo(r exec.Runner, fsys hostfs.FS, version string) error {
+	if d.repoDir != "" {
+		return d.addLocalRepo(fsys)
+	}
+
+	baseURL := fmt.Sprintf("https://pkgs.k8s.io/core:/stable:/v%s/rpm/", minorVersion(version))
+	repoContent := fmt.Sprintf(`[kubernetes]
+name=Kubernetes
+baseurl=%s
+enabled=1
+gpgcheck=1
+gpgkey=%srepodata/repomd.xml.key
+`, baseURL, baseURL)
+	return fsys.WriteFile(dnfKubernetesRepo, []byte(repoContent), 0644)
+}
+
+// addLocalRepo writes the repository entry for the bundle. The packages
+// aren't checked against their signing keys, which aren't in the bundle;
+// the bundle as a whole is what the user trusts.
+func (d dnf) addLocalRepo(fsys hostfs.FS) error {
+	repoContent := fmt.Sprintf(`[%s]
+name=go-install-kubernetes bundle
+baseurl=file://%s
+enabled=1
+gpgcheck=0
+`, localRepo, d.repoDir)
+	return fsys.WriteFile(dnfLocalRepo, []byte(repoContent), 0644)
+}
+
+func (d dnf) RepoFiles() []string {
+	return []string{dnfKubernetesRepo, dnfDockerRepo, dnfLocalRepo}
+}
+
+func (d dnf) KubeletEnvFile() string {
+	return "/etc/sysconfig/kubelet"
+}
+
+// ConfigureSystem puts SELinux in permissive mode, as kubeadm requires, if
+// opts allow it, and opens the cluster's ports in firewalld when it is
+// running.
+func (d dnf) ConfigureSystem(r exec.Runner, fsys hostfs.FS, opts SystemOptions) error {
+	if err := d.configureSELinux(r, fsys, opts.SELinuxPermissive); err != nil {
+		return err
+	}
+	return d.configureFirewall(r, opts)
+}
+
+func (d dnf) configureSELinux(r exec.Runner, fsys hostfs.FS, permissive bool) error {
+	content, err := fsys.ReadFile(selinuxConfig)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	enforcing := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "SELINUX=enforcing" {
+			lines[i] = "SELINUX=permissive"
+			enforcing = true
+		}
+	}
+
+	// getenforce fails when SELinux isn't installed, which is fine
+	mode, installed := "", false
+	if res, err := r.Run("getenforce"); err == nil {
+		mode, installed = strings.TrimSpace(res.Stdout), true
+	}
+	if !enforcing && mode != "Enforcing" {
+		return nil
+	}
+	if !permissive {
+		fmt.Println("Warning: SELinux is enforcing, which kubeadm doesn't support. Set selinuxPermissive to switch it to permissive mode.")
+		return nil
+	}
+
+	if enforcing {
+		if err := fsys.WriteFile(selinuxConfig, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+			return err
+		}
+	}
+	// setenforce fails when SELinux is disabled, which is fine
+	if installed && mode != "Disabled" {
+		if _, err := r.Run("setenforce 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureFirewall opens opts.FirewallPorts and trusts opts.TrustedSources
+// in firewalld's default zone, leaving the rest of its rules alone.
+func (d dnf) configureFirewall(r exec.Runner, opts SystemOptions) error {
+	// is-active fails when firewalld isn't installed or is stopped
+	if _, err := r.Run("systemctl is-active firewalld"); err != nil {
+		return nil
+	}
+
+	var args []string
+	for _, port := range opts.FirewallPorts {
+		args = append(args, "--add-port="+port)
+	}
+	if len(args) > 0 {
+		if _, err := r.Run("firewall-cmd --permanent " + strings.Join(args, " ")); err != nil {
+			return err
+		}
+	}
+
+	args = nil
+	for _, source := range opts.TrustedSources {
+		args = append(args, "--add-source="+source)
+	}
+	if len(args) > 0 {
+		if _, err := r.Run("firewall-cmd --permanent --zone=trusted " + strings.Join(args, " ")); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.Run("firewall-cmd --reload")
+	return err
+}
+
+func (d dnf) BundleTools() []string {
+	return []string{"createrepo_c", "dnf-plugins-core", "zstd"}
+}
+
+func (d dnf) DownloadPackages(r exec.Runner, fsys hostfs.FS, dir string, pkgs ...string) error {
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return run(r, d.dnf(fmt.Sprintf("download --resolve --alldeps --destdir %s %s", fsys.Path(dir), strings.Join(pkgs, " "))))
+}
+
+func (d dnf) IndexPackages(r exec.Runner, fsys hostfs.FS, dir string) error {
+	return run(r, fmt.Sprintf("createrepo_c %s", fsys.Path(dir)))
+}
+
+func (d dnf) Offline(fsys hostfs.FS, dir string) Distro {
+	d.repoDir = fsys.Path(dir)
+	return d
+}
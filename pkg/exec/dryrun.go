@@ -0,0 +1,21 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+)
+
+// NewDryRunner returns a Runner that prints each command to w instead of
+// running it. Every command succeeds with no output.
+func NewDryRunner(w io.Writer) Runner {
+	return &dryRunner{w: w}
+}
+
+type dryRunner struct {
+	w io.Writer
+}
+
+func (d *dryRunner) Run(cmd string) (Result, error) {
+	fmt.Fprintf(d.w, "  run:   %s\n", cmd)
+	return Result{}, nil
+}
@@ -3,35 +3,69 @@ package exec
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"go-install-kubernetes/pkg/config"
 
 	"github.com/bitfield/script"
 )
 
-func Command(cmd string, cfg *config.Config) (string, error) {
-	pipe := script.Exec(cmd)
-	output, err := pipe.String()
-	if err != nil {
-		return "", err
+// Result is the captured outcome of a single command.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Runner runs shell commands. The install steps only ever talk to the host
+// through a Runner so they can be exercised without a real node.
+type Runner interface {
+	Run(cmd string) (Result, error)
+}
+
+// Quote makes s a single word of a command for Run, which splits commands
+// the way a POSIX shell does without running one.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// NewRunner returns a Runner that executes commands on the local host,
+// appending every command and its output to cfg.LogFile.
+func NewRunner(cfg *config.Config) Runner {
+	return &hostRunner{cfg: cfg}
+}
+
+type hostRunner struct {
+	cfg *config.Config
+}
+
+func (h *hostRunner) Run(cmd string) (Result, error) {
+	var stderr strings.Builder
+	pipe := script.NewPipe().WithStderr(&stderr).Exec(cmd)
+	stdout, runErr := pipe.String()
+
+	res := Result{
+		Stdout:   stdout,
+		Stderr:   stderr.String(),
+		ExitCode: pipe.ExitStatus(),
 	}
 
 	// Always append to log file
-	f, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(h.cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return "", err
+		return res, err
 	}
 	defer f.Close()
 
 	// Write command and output to log file
-	if _, err := fmt.Fprintf(f, "\n$ %s\n%s\n", cmd, output); err != nil {
-		return "", err
+	if _, err := fmt.Fprintf(f, "\n$ %s\n%s%s\n", cmd, res.Stdout, res.Stderr); err != nil {
+		return res, err
 	}
 
 	// If verbose, also print to stdout
-	if cfg.IsVerbose {
-		fmt.Printf("$ %s\n%s\n", cmd, output)
+	if h.cfg.IsVerbose {
+		fmt.Printf("$ %s\n%s%s\n", cmd, res.Stdout, res.Stderr)
 	}
 
-	return output, nil
+	return res, runErr
 }
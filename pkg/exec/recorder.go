@@ -0,0 +1,92 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Recorder is a Runner that records commands instead of executing them. It
+// is meant for exercising install steps offline: script the responses a
+// step needs with On, run the step, then check the sequence with Expect.
+type Recorder struct {
+	mu        sync.Mutex
+	commands  []string
+	responses []response
+}
+
+type response struct {
+	prefix  string
+	results []Result
+}
+
+// NewRecorder returns an empty Recorder. Unscripted commands succeed with no
+// output.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// On scripts the results returned for commands starting with prefix. When
+// several results are given they are returned in order, and the last one is
+// repeated once the others are used up. Later calls take precedence over
+// earlier ones for the same command.
+func (r *Recorder) On(prefix string, results ...Result) *Recorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(results) == 0 {
+		results = []Result{{}}
+	}
+	r.responses = append(r.responses, response{prefix: prefix, results: results})
+	return r
+}
+
+// Run records cmd and returns the scripted result for it. A non-zero exit
+// code is reported as an error, the same way the host runner does.
+func (r *Recorder) Run(cmd string) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, cmd)
+
+	var res Result
+	for i := len(r.responses) - 1; i >= 0; i-- {
+		resp := &r.responses[i]
+		if !strings.HasPrefix(cmd, resp.prefix) {
+			continue
+		}
+		res = resp.results[0]
+		if len(resp.results) > 1 {
+			resp.results = resp.results[1:]
+		}
+		break
+	}
+
+	if res.ExitCode != 0 {
+		return res, fmt.Errorf("exit status %d", res.ExitCode)
+	}
+	return res, nil
+}
+
+// Commands returns every command run so far, in order.
+func (r *Recorder) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+// Expect checks that the recorded commands match want exactly, in order.
+// Each entry in want only needs to be a prefix of the recorded command, so
+// temporary paths and generated names don't have to be known up front.
+func (r *Recorder) Expect(want ...string) error {
+	got := r.Commands()
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return fmt.Errorf("command %d: expected %q, got nothing", i, want[i])
+		case i >= len(want):
+			return fmt.Errorf("command %d: unexpected %q", i, got[i])
+		case !strings.HasPrefix(got[i], want[i]):
+			return fmt.Errorf("command %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+	return nil
+}
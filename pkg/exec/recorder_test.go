@@ -0,0 +1,85 @@
+package exec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder().
+		On("kubeadm version", Result{Stdout: "v1.30.9\n"}, Result{Stdout: "v1.31.5\n"}).
+		On("systemctl is-active", Result{ExitCode: 3, Stdout: "inactive\n"})
+
+	for _, want := range []string{"v1.30.9\n", "v1.31.5\n", "v1.31.5\n"} {
+		res, err := r.Run("kubeadm version -o short")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Stdout != want {
+			t.Errorf("got %q, want %q", res.Stdout, want)
+		}
+	}
+
+	res, err := r.Run("systemctl is-active containerd")
+	if err == nil || res.ExitCode != 3 {
+		t.Errorf("expected exit status 3, got %v with %d", err, res.ExitCode)
+	}
+
+	if res, err := r.Run("swapoff -a"); err != nil || res.Stdout != "" {
+		t.Errorf("unscripted command returned %+v, %v", res, err)
+	}
+
+	err = r.Expect(
+		"kubeadm version",
+		"kubeadm version",
+		"kubeadm version -o short",
+		"systemctl is-active containerd",
+		"swapoff",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecorderLaterScriptWins(t *testing.T) {
+	r := NewRecorder().On("ip", Result{Stdout: "any"}).On("ip route", Result{Stdout: "route"})
+	if res, _ := r.Run("ip route get 1"); res.Stdout != "route" {
+		t.Errorf("got %q, want the later script", res.Stdout)
+	}
+	if res, _ := r.Run("ip link show"); res.Stdout != "any" {
+		t.Errorf("got %q, want the earlier script", res.Stdout)
+	}
+}
+
+func TestRecorderExpectMismatch(t *testing.T) {
+	r := NewRecorder()
+	r.Run("apt-get update")
+
+	tests := []struct {
+		want []string
+		err  string
+	}{
+		{[]string{"apt-get update", "apt-get install"}, `command 1: expected "apt-get install", got nothing`},
+		{nil, `command 0: unexpected "apt-get update"`},
+		{[]string{"dnf makecache"}, `command 0: expected "dnf makecache", got "apt-get update"`},
+	}
+	for _, tt := range tests {
+		err := r.Expect(tt.want...)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Expect(%q) = %v, want %s", tt.want, err, tt.err)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"bootstrap", "'bootstrap'"},
+		{"it's $HOME", `'it'\''s $HOME'`},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package exec
+
+import (
+	"io"
+	"strings"
+
+	"github.com/bitfield/script"
+)
+
+// NewStreamRunner returns a Runner that executes commands on the local host
+// and copies their output to w as it is produced, for commands that run long
+// enough that waiting for the whole output would leave the user guessing.
+func NewStreamRunner(w io.Writer) Runner {
+	return &streamRunner{w: w}
+}
+
+type streamRunner struct {
+	w io.Writer
+}
+
+func (s *streamRunner) Run(cmd string) (Result, error) {
+	var stdout, stderr strings.Builder
+	pipe := script.NewPipe().
+		WithStderr(io.MultiWriter(&stderr, s.w)).
+		Exec(cmd).
+		WithStdout(io.MultiWriter(&stdout, s.w))
+	_, err := pipe.Stdout()
+
+	return Result{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: pipe.ExitStatus(),
+	}, err
+}
@@ -0,0 +1,74 @@
+package hostfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewDryRun wraps base so that reads still come from it but every change is
+// printed to w instead of being made. Files written during the dry run can
+// be read back, so later steps see what earlier steps would have written.
+func NewDryRun(base FS, w io.Writer) FS {
+	return &dryRunFS{base: base, w: w, written: map[string][]byte{}}
+}
+
+type dryRunFS struct {
+	base    FS
+	w       io.Writer
+	written map[string][]byte
+}
+
+func (d *dryRunFS) Path(name string) string {
+	return d.base.Path(name)
+}
+
+func (d *dryRunFS) ReadFile(name string) ([]byte, error) {
+	if data, ok := d.written[name]; ok {
+		return data, nil
+	}
+	return d.base.ReadFile(name)
+}
+
+func (d *dryRunFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	d.written[name] = data
+	fmt.Fprintf(d.w, "  write: %s %04o sha256:%x\n", d.Path(name), perm, sha256.Sum256(data))
+	return nil
+}
+
+func (d *dryRunFS) MkdirAll(name string, perm fs.FileMode) error {
+	fmt.Fprintf(d.w, "  mkdir: %s %04o\n", d.Path(name), perm)
+	return nil
+}
+
+// MkdirTemp returns a fixed name so that plans are stable between runs.
+func (d *dryRunFS) MkdirTemp(pattern string) (string, error) {
+	dir := filepath.Join(os.TempDir(), strings.Replace(pattern, "*", "dryrun", 1))
+	fmt.Fprintf(d.w, "  mkdir: %s 0700\n", d.Path(dir))
+	return dir, nil
+}
+
+func (d *dryRunFS) Chmod(name string, perm fs.FileMode) error {
+	fmt.Fprintf(d.w, "  chmod: %s %04o\n", d.Path(name), perm)
+	return nil
+}
+
+func (d *dryRunFS) Remove(name string) error {
+	delete(d.written, name)
+	fmt.Fprintf(d.w, "  rm:    %s\n", d.Path(name))
+	return nil
+}
+
+func (d *dryRunFS) RemoveAll(name string) error {
+	for path := range d.written {
+		if path == name || strings.HasPrefix(path, name+"/") {
+			delete(d.written, path)
+		}
+	}
+	fmt.Fprintf(d.w, "  rm -r: %s\n", d.Path(name))
+	return nil
+}
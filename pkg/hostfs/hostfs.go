@@ -0,0 +1,89 @@
+package hostfs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the host filesystem as seen by the install steps. Paths are always
+// absolute host paths such as /etc/crictl.yaml; the implementation decides
+// where they actually live.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	MkdirTemp(pattern string) (string, error)
+	Chmod(name string, perm fs.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+
+	// Path returns the real location of name, for handing to commands.
+	Path(name string) string
+}
+
+// New returns an FS rooted at root. An empty root (or "/") is the host
+// itself; anything else prefixes every path, so an install can be rendered
+// into a scratch directory.
+func New(root string) FS {
+	if root == "/" {
+		root = ""
+	}
+	return &dirFS{root: root}
+}
+
+type dirFS struct {
+	root string
+}
+
+func (d *dirFS) Path(name string) string {
+	if d.root == "" {
+		return name
+	}
+	return filepath.Join(d.root, name)
+}
+
+func (d *dirFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(d.Path(name))
+}
+
+func (d *dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	// Parent directories always exist on a real host, but not in a
+	// freshly created root
+	if d.root != "" {
+		if err := os.MkdirAll(filepath.Dir(d.Path(name)), 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(d.Path(name), data, perm)
+}
+
+func (d *dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(d.Path(name), perm)
+}
+
+// MkdirTemp creates a new temporary directory and returns its host path.
+func (d *dirFS) MkdirTemp(pattern string) (string, error) {
+	base := d.Path(os.TempDir())
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(base, pattern)
+	if err != nil {
+		return "", err
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(dir, d.root), "/"), nil
+}
+
+func (d *dirFS) Chmod(name string, perm fs.FileMode) error {
+	return os.Chmod(d.Path(name), perm)
+}
+
+func (d *dirFS) Remove(name string) error {
+	return os.Remove(d.Path(name))
+}
+
+func (d *dirFS) RemoveAll(name string) error {
+	return os.RemoveAll(d.Path(name))
+}
@@ -0,0 +1,93 @@
+package hostfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRootedFS(t *testing.T) {
+	root := t.TempDir()
+	fsys := New(root)
+
+	// Parent directories are created under a fresh root
+	if err := fsys.WriteFile("/etc/crictl.yaml", []byte("runtime-endpoint: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "crictl.yaml")); err != nil {
+		t.Fatalf("file not written under the root: %v", err)
+	}
+	if got, want := fsys.Path("/etc/crictl.yaml"), filepath.Join(root, "etc/crictl.yaml"); got != want {
+		t.Errorf("Path = %s, want %s", got, want)
+	}
+
+	dir, err := fsys.MkdirTemp("kubeadm-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dir, os.TempDir()+"/kubeadm-") {
+		t.Errorf("MkdirTemp returned %s, want a host path under %s", dir, os.TempDir())
+	}
+	if _, err := os.Stat(fsys.Path(dir)); err != nil {
+		t.Errorf("temp directory not created under the root: %v", err)
+	}
+
+	if _, err := fsys.ReadFile("/etc/fstab"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a missing file gave %v, want ErrNotExist", err)
+	}
+}
+
+func TestHostFS(t *testing.T) {
+	for _, root := range []string{"", "/"} {
+		if got := New(root).Path("/etc/fstab"); got != "/etc/fstab" {
+			t.Errorf("New(%q).Path = %s, want /etc/fstab", root, got)
+		}
+	}
+}
+
+func TestDryRunFS(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "fstab"), []byte("original\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	fsys := NewDryRun(New(root), &out)
+
+	if err := fsys.WriteFile("/etc/fstab", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Later steps see the planned content, the host keeps its own
+	if got, _ := fsys.ReadFile("/etc/fstab"); string(got) != "changed\n" {
+		t.Errorf("read back %q after a dry run write", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "etc", "fstab")); string(got) != "original\n" {
+		t.Errorf("dry run changed the file to %q", got)
+	}
+
+	dir, _ := fsys.MkdirTemp("k8s-gpg-*")
+	fsys.RemoveAll("/etc")
+	if got, _ := fsys.ReadFile("/etc/fstab"); string(got) != "original\n" {
+		t.Errorf("read %q after removing the planned write", got)
+	}
+
+	want := "  write: " + root + "/etc/fstab 0644 sha256:"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("output %q doesn't start with %q", out.String(), want)
+	}
+	for _, line := range []string{"  mkdir: " + root + dir + " 0700\n", "  rm -r: " + root + "/etc\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output %q is missing %q", out.String(), line)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "tmp")); len(entries) > 0 {
+		t.Errorf("dry run created %v", entries)
+	}
+}
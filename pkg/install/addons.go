@@ -0,0 +1,354 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/kube"
+	"go-install-kubernetes/pkg/wait"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Addons actions
+const (
+	AddonsList    = "list"
+	AddonsEnable  = "enable"
+	AddonsDisable = "disable"
+	AddonsDiff    = "diff"
+)
+
+// Embedded manifests for the addons
+const (
+	metricsServerManifest = "manifests/metrics-server.yaml"
+	ingressNginxManifest  = "manifests/ingress-nginx.yaml"
+	localPathManifest     = "manifests/local-path-provisioner.yaml.tmpl"
+)
+
+// LocalPathProvisioner is the addon that provides the default StorageClass.
+const LocalPathProvisioner = "local-path-provisioner"
+
+var apiServices = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+// addon is an optional component applied to the cluster once the pod network
+// is up.
+type addon struct {
+	name        string
+	version     string
+	description string
+	manifests   []string
+	requires    []string
+
+	// The addon's main Deployment, which shows whether it is enabled and
+	// must be available for the addon to be ready
+	namespace  string
+	deployment string
+
+	// ready, if set, waits for more than the Deployment
+	ready func(c *kube.Client) wait.Condition
+}
+
+// addonRegistry lists the addons that can be enabled, in the order they are
+// shown and installed.
+var addonRegistry = []addon{
+	{
+		name:        "metrics-server",
+		version:     "0.6.3",
+		description: "Resource metrics for kubectl top and autoscaling",
+		manifests:   []string{metricsServerManifest},
+		namespace:   "kube-system",
+		deployment:  "metrics-server",
+		ready: func(c *kube.Client) wait.Condition {
+			return wait.ConditionTrue(c, apiServices, "", "v1beta1.metrics.k8s.io", "Available")
+		},
+	},
+	{
+		name:        LocalPathProvisioner,
+		version:     "0.0.30",
+		description: "Default StorageClass backed by a directory on each node",
+		manifests:   []string{localPathManifest},
+		namespace:   "local-path-storage",
+		deployment:  "local-path-provisioner",
+	},
+	{
+		name:        "ingress-nginx",
+		version:     "1.12.0",
+		description: "Ingress controller on a NodePort service, the default IngressClass",
+		manifests:   []string{ingressNginxManifest},
+		namespace:   "ingress-nginx",
+		deployment:  "ingress-nginx-controller",
+	},
+}
+
+func lookupAddon(name string) (addon, bool) {
+	for _, a := range addonRegistry {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return addon{}, false
+}
+
+// ValidateAddons checks that every named addon exists and that their
+// dependencies can be met.
+func ValidateAddons(names []string) error {
+	_, err := resolveAddons(names)
+	return err
+}
+
+// resolveAddons returns the named addons and everything they require, each
+// after its dependencies.
+func resolveAddons(names []string) ([]addon, error) {
+	var (
+		resolved []addon
+		visiting = map[string]bool{}
+		done     = map[string]bool{}
+		visit    func(name, from string) error
+	)
+	visit = func(name, from string) error {
+		if done[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("addon %s has a dependency cycle", name)
+		}
+		a, ok := lookupAddon(name)
+		if !ok {
+			if from != "" {
+				return fmt.Errorf("addon %s requires unknown addon %s", from, name)
+			}
+			return fmt.Errorf("unknown addon %q, see addons list", name)
+		}
+
+		visiting[name] = true
+		for _, dep := range a.requires {
+			if err := visit(dep, name); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+		resolved = append(resolved, a)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// addonSteps returns an install step for each addon in the settings.
+func addonSteps(cfg *config.Config) []step {
+	// Kubernetes has already checked the list
+	addons, _ := resolveAddons(cfg.Addons)
+
+	var steps []step
+	for _, a := range addons {
+		a := a
+		steps = append(steps, step{"Install " + a.name, func(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+			client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+			if err != nil {
+				return err
+			}
+			return enableAddon(cfg, client, manifestFiles, a)
+		}})
+	}
+	return steps
+}
+
+// enableAddon applies an addon's manifests and waits for it to be ready.
+// Without a pod network, or on a cluster whose only nodes are tainted
+// control plane nodes, its pods can't start, so then it doesn't wait.
+func enableAddon(cfg *config.Config, client *kube.Client, manifestFiles fs.FS, a addon) error {
+	for _, path := range a.manifests {
+		if err := applyManifest(cfg, client, manifestFiles, path); err != nil {
+			return err
+		}
+	}
+	if cfg.CNI == config.CNINone {
+		return nil
+	}
+	schedulable, err := client.HasSchedulableNode(context.Background())
+	if err != nil {
+		return err
+	}
+	if !schedulable && !cfg.DryRun {
+		fmt.Printf("No node can run %s yet, it starts once a worker joins\n", a.name)
+		return nil
+	}
+	return waitFor(cfg, a.name, a.readyCondition(client))
+}
+
+func (a addon) readyCondition(client *kube.Client) wait.Condition {
+	cond := wait.DeploymentAvailable(client, a.namespace, a.deployment)
+	if a.ready != nil {
+		cond = wait.All(cond, a.ready(client))
+	}
+	return cond
+}
+
+// enabled reports whether the addon is installed on the cluster.
+func (a addon) enabled(ctx context.Context, client *kube.Client) (bool, error) {
+	_, err := client.Clientset.AppsV1().Deployments(a.namespace).Get(ctx, a.deployment, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Addons manages the optional components on an existing cluster.
+func Addons(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.Addon.Action {
+	case AddonsList:
+		return listAddons(client)
+	case AddonsEnable:
+		addons, err := resolveAddons(cfg.Addon.Names)
+		if err != nil {
+			return err
+		}
+		for _, a := range addons {
+			fmt.Printf("Enabling %s %s...\n", a.name, a.version)
+			if err := enableAddon(cfg, client, manifestFiles, a); err != nil {
+				return fmt.Errorf("failed to enable %s: %v", a.name, err)
+			}
+		}
+		return nil
+	case AddonsDisable:
+		return disableAddons(cfg, client, manifestFiles, cfg.Addon.Names)
+	case AddonsDiff:
+		return diffAddons(cfg, client, manifestFiles)
+	default:
+		return fmt.Errorf("unknown addons action %q", cfg.Addon.Action)
+	}
+}
+
+func listAddons(client *kube.Client) error {
+	ctx := context.Background()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tSTATUS\tREQUIRES\tDESCRIPTION")
+	for _, a := range addonRegistry {
+		status := "disabled"
+		enabled, err := a.enabled(ctx, client)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			status = "ready"
+			if done, _, err := a.readyCondition(client)(ctx); err != nil || !done {
+				status = "not ready"
+			}
+		}
+
+		requires := strings.Join(a.requires, ",")
+		if requires == "" {
+			requires = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.name, a.version, status, requires, a.description)
+	}
+	return w.Flush()
+}
+
+// disableAddons deletes the addons' objects, refusing if an addon that stays
+// enabled depends on one of them.
+func disableAddons(cfg *config.Config, client *kube.Client, manifestFiles fs.FS, names []string) error {
+	ctx := context.Background()
+	for _, name := range names {
+		if _, ok := lookupAddon(name); !ok {
+			return fmt.Errorf("unknown addon %q, see addons list", name)
+		}
+	}
+
+	for _, other := range addonRegistry {
+		if slices.Contains(names, other.name) {
+			continue
+		}
+		for _, dep := range other.requires {
+			if !slices.Contains(names, dep) {
+				continue
+			}
+			enabled, err := other.enabled(ctx, client)
+			if err != nil {
+				return err
+			}
+			if enabled {
+				return fmt.Errorf("%s is required by %s, disable that too", dep, other.name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		a, _ := lookupAddon(name)
+		fmt.Printf("Disabling %s...\n", a.name)
+		for i := len(a.manifests) - 1; i >= 0; i-- {
+			manifest, err := RenderManifest(cfg, manifestFiles, a.manifests[i])
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", a.manifests[i], err)
+			}
+			if err := client.Delete(ctx, manifest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffAddons prints how the live objects of the pod network and the enabled
+// addons differ from the embedded manifests rendered with the current
+// settings, such as after someone edits them by hand or after upgrading to a
+// binary with newer manifests.
+func diffAddons(cfg *config.Config, client *kube.Client, manifestFiles fs.FS) error {
+	ctx := context.Background()
+	manifests := cniManifests(cfg)
+	for _, a := range addonRegistry {
+		enabled, err := a.enabled(ctx, client)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			manifests = append(manifests, a.manifests...)
+		}
+	}
+
+	var drifted []string
+	for _, path := range manifests {
+		manifest, err := RenderManifest(cfg, manifestFiles, path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		changed, err := client.Diff(ctx, manifest, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if changed {
+			drifted = append(drifted, path)
+		}
+	}
+
+	if len(drifted) == 0 {
+		fmt.Println("The cluster matches the embedded manifests")
+		return nil
+	}
+	fmt.Printf("\n%d manifest(s) differ from the cluster:\n", len(drifted))
+	for _, path := range drifted {
+		fmt.Printf("  %s\n", path)
+	}
+	return nil
+}
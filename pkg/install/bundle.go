@@ -0,0 +1,424 @@
+package install
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/distro"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Bundle actions
+const BundleCreate = "create"
+
+// BundleDir is where an install with --bundle unpacks the bundle. The
+// package repository stays there for as long as the packages are installed.
+const BundleDir = "/var/lib/go-install-kubernetes/bundle"
+
+// Layout of a bundle
+const (
+	bundleInfoFile     = "bundle.yaml"
+	bundlePackages     = "packages"
+	bundleImageArchive = "images.tar"
+	bundleManifests    = "manifests"
+)
+
+// bundleNamespace is the containerd namespace bundle create pulls images
+// into, apart from the ones the kubelet uses.
+const bundleNamespace = "go-install-kubernetes"
+
+// calicoComponents are the images the Tigera operator pulls for Calico,
+// which the manifests don't name.
+var calicoComponents = []string{"apiserver", "cni", "csi", "kube-controllers", "node", "node-driver-registrar", "pod2daemon-flexvol", "typha"}
+
+// bundleInfo records what a bundle was made for, so an install can refuse
+// one that doesn't match instead of failing to pull without a network.
+type bundleInfo struct {
+	CreatedAt         time.Time `yaml:"createdAt"`
+	OS                string    `yaml:"os"`
+	Arch              string    `yaml:"arch"`
+	KubernetesVersion string    `yaml:"kubernetesVersion"`
+	Images            []string  `yaml:"images"`
+}
+
+// Bundle carries out a bundle command.
+func Bundle(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	switch cfg.Bundle.Action {
+	case BundleCreate:
+		return createBundle(cfg, r, fsys, manifestFiles)
+	default:
+		return fmt.Errorf("unknown bundle action %q", cfg.Bundle.Action)
+	}
+}
+
+// bundleBuild holds what the bundle create steps build up as they go.
+type bundleBuild struct {
+	distro        distro.Distro
+	stage         string
+	images        []string
+	manifestFiles fs.FS
+}
+
+// createBundle gathers everything an install with the current settings
+// downloads into one archive. It has to run on the same release and
+// architecture as the nodes, since that is what the packages are for.
+func createBundle(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	if err := ValidateAddons(cfg.Addons); err != nil {
+		return err
+	}
+	if cfg.ContainerdSource == config.ContainerdSourceRelease {
+		return fmt.Errorf("bundles hold the containerd package, so containerdSource must be %s", config.ContainerdSourcePackage)
+	}
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Creating a bundle for %s on %s\n", d.Name(), runtime.GOARCH)
+
+	stage, err := fsys.MkdirTemp("gik-bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer fsys.RemoveAll(stage)
+
+	b := &bundleBuild{distro: d, stage: stage, manifestFiles: manifestFiles}
+	steps := []struct {
+		name string
+		fn   func(*config.Config, exec.Runner, hostfs.FS) error
+	}{
+		{"Configure package repositories", b.configureRepos},
+		{"Download packages", b.downloadPackages},
+		{"Export manifests", b.exportManifests},
+		{"Pull images", b.pullImages},
+		{"Write bundle", b.writeBundle},
+	}
+
+	for _, step := range steps {
+		fmt.Printf("Executing: %s...\n", step.name)
+		if err := step.fn(cfg, r, fsys); err != nil {
+			return fmt.Errorf("%s failed: %v", step.name, err)
+		}
+	}
+
+	fmt.Printf("Bundle written to %s\n", cfg.Bundle.Output)
+	return nil
+}
+
+func (b *bundleBuild) configureRepos(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if err := b.distro.AddContainerdRepo(r, fsys); err != nil {
+		return err
+	}
+	if err := b.distro.AddKubernetesRepo(r, fsys, cfg.KubeVersion); err != nil {
+		return err
+	}
+	if err := b.distro.Refresh(r); err != nil {
+		return err
+	}
+	return b.distro.Install(r, b.distro.BundleTools()...)
+}
+
+func (b *bundleBuild) downloadPackages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	pkgs := append(b.distro.BasePackages(), b.distro.ContainerdPackage())
+	for _, pkg := range kubePackages {
+		pkgs = append(pkgs, b.distro.Pin(pkg, cfg.KubeVersion))
+	}
+
+	dir := filepath.Join(b.stage, bundlePackages)
+	if err := b.distro.DownloadPackages(r, fsys, dir, pkgs...); err != nil {
+		return err
+	}
+	return b.distro.IndexPackages(r, fsys, dir)
+}
+
+// exportManifests adds the embedded manifests, rendered with the current
+// settings, for reference and for applying by hand.
+func (b *bundleBuild) exportManifests(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	return fs.WalkDir(b.manifestFiles, "manifests", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(b.stage, bundleManifests, strings.TrimPrefix(strings.TrimSuffix(path, ".tmpl"), "manifests"))
+		if entry.IsDir() {
+			return fsys.MkdirAll(target, 0755)
+		}
+
+		content, err := RenderManifest(cfg, b.manifestFiles, path)
+		if err != nil {
+			return err
+		}
+		return fsys.WriteFile(target, content, 0644)
+	})
+}
+
+func (b *bundleBuild) pullImages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	images, err := bundleImages(cfg, b.manifestFiles)
+	if err != nil {
+		return err
+	}
+
+	// kubeadm knows which control plane images its release uses
+	tmpDir, err := fsys.MkdirTemp("kubeadm-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer fsys.RemoveAll(tmpDir)
+	kubeadm, err := downloadRelease(cfg, r, fsys, tmpDir, kubeadmAsset(cfg.KubeVersion))
+	if err != nil {
+		return err
+	}
+	if err := fsys.Chmod(kubeadm, 0755); err != nil {
+		return err
+	}
+	listCmd := fmt.Sprintf("%s config images list --kubernetes-version v%s", fsys.Path(kubeadm), cfg.KubeVersion)
+	if cfg.ImageRepository != "" {
+		listCmd += " --image-repository " + cfg.ImageRepository
+	}
+	res, err := r.Run(listCmd)
+	if err != nil {
+		return err
+	}
+	for _, image := range strings.Fields(res.Stdout) {
+		images = append(images, imageRef(image))
+	}
+	slices.Sort(images)
+	images = slices.Compact(images)
+
+	platform := "linux/" + runtime.GOARCH
+	for _, image := range images {
+		if _, err := r.Run(fmt.Sprintf("ctr -n %s images pull --platform %s %s", bundleNamespace, platform, image)); err != nil {
+			return err
+		}
+	}
+	refs := strings.Join(images, " ")
+	archive := fsys.Path(filepath.Join(b.stage, bundleImageArchive))
+	if _, err := r.Run(fmt.Sprintf("ctr -n %s images export --platform %s %s %s", bundleNamespace, platform, archive, refs)); err != nil {
+		return err
+	}
+	if _, err := r.Run(fmt.Sprintf("ctr -n %s images rm %s", bundleNamespace, refs)); err != nil {
+		return err
+	}
+
+	b.images = images
+	return nil
+}
+
+func (b *bundleBuild) writeBundle(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	info := bundleInfo{
+		CreatedAt:         time.Now().UTC(),
+		OS:                b.distro.Name(),
+		Arch:              runtime.GOARCH,
+		KubernetesVersion: cfg.KubeVersion,
+		Images:            b.images,
+	}
+	content, err := yaml.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(filepath.Join(b.stage, bundleInfoFile), content, 0644); err != nil {
+		return err
+	}
+
+	_, err = r.Run(fmt.Sprintf("tar --zstd -cf %s -C %s .", exec.Quote(cfg.Bundle.Output), fsys.Path(b.stage)))
+	return err
+}
+
+// bundleImages returns the images an install with cfg runs, other than the
+// control plane images kubeadm pulls.
+func bundleImages(cfg *config.Config, manifestFiles fs.FS) ([]string, error) {
+	paths := cniManifests(cfg)
+	addons, err := resolveAddons(cfg.Addons)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addons {
+		paths = append(paths, a.manifests...)
+	}
+	// kube-vip is always included so the bundle also serves an HA install
+	paths = append(paths, kubeVIPTemplate)
+
+	var images []string
+	for _, path := range paths {
+		content, err := RenderManifest(cfg, manifestFiles, path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := manifestImages(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read images from %s: %v", path, err)
+		}
+		images = append(images, found...)
+	}
+
+	if cfg.CNI == config.CNICalico {
+		for _, component := range calicoComponents {
+			images = append(images, fmt.Sprintf("docker.io/calico/%s:v%s", component, cfg.CalicoVersion))
+		}
+	}
+
+	// The test pods, and the local-path helper pod, which is named inside a
+	// ConfigMap rather than as a container
+	images = append(images, nginxImage, busyboxImage)
+
+	// kubeadm lists the default pause image, but not one set in the settings
+	images = append(images, sandboxImage(cfg))
+
+	for i, image := range images {
+		images[i] = imageRef(image)
+	}
+	slices.Sort(images)
+	return slices.Compact(images), nil
+}
+
+// manifestImages returns the container images named in a manifest.
+func manifestImages(content []byte) ([]string, error) {
+	var images []string
+	var walk func(node any)
+	walk = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			for key, value := range node {
+				if image, ok := value.(string); ok && key == "image" {
+					images = append(images, image)
+					continue
+				}
+				walk(value)
+			}
+		case []any:
+			for _, value := range node {
+				walk(value)
+			}
+		}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc any
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		walk(doc)
+	}
+	return images, nil
+}
+
+// imageRef returns the fully qualified reference ctr needs for image, such
+// as docker.io/library/nginx:latest for nginx.
+func imageRef(image string) string {
+	domain, _, found := strings.Cut(image, "/")
+	if !found {
+		image = "docker.io/library/" + image
+	} else if !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		image = "docker.io/" + image
+	}
+
+	name := image[strings.LastIndex(image, "/")+1:]
+	if !strings.ContainsAny(name, ":@") {
+		image += ":latest"
+	}
+	return image
+}
+
+// hostDistro returns the node's Distro, which installs from the bundle's
+// repository when there is one.
+func hostDistro(cfg *config.Config, fsys hostfs.FS) (distro.Distro, error) {
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BundlePath != "" {
+		d = d.Offline(fsys, filepath.Join(BundleDir, bundlePackages))
+	}
+	return d, nil
+}
+
+// bundleExtracted reports whether an earlier install unpacked a bundle.
+func bundleExtracted(fsys hostfs.FS) bool {
+	_, err := fsys.ReadFile(filepath.Join(BundleDir, bundleInfoFile))
+	return err == nil
+}
+
+// extractBundle unpacks the bundle onto the node and checks that it holds
+// everything the install needs.
+func extractBundle(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+
+	// tar runs zstd to unpack, and there is no network to install it from
+	if _, err := r.Run("zstd --version"); err != nil {
+		return fmt.Errorf("zstd is needed to unpack the bundle, install the zstd package on this node first: %v", err)
+	}
+
+	if err := fsys.RemoveAll(BundleDir); err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(BundleDir, 0755); err != nil {
+		return err
+	}
+	if _, err := r.Run(fmt.Sprintf("tar --zstd -xf %s -C %s", exec.Quote(cfg.BundlePath), fsys.Path(BundleDir))); err != nil {
+		return err
+	}
+	// The package steps before Install containerd use the bundle too
+	if err := d.Offline(fsys, filepath.Join(BundleDir, bundlePackages)).AddContainerdRepo(r, fsys); err != nil {
+		return err
+	}
+
+	content, err := fsys.ReadFile(filepath.Join(BundleDir, bundleInfoFile))
+	if err != nil {
+		// Nothing was unpacked in a dry run
+		if cfg.DryRun {
+			return nil
+		}
+		return fmt.Errorf("failed to read bundle info: %v", err)
+	}
+	var info bundleInfo
+	if err := yaml.Unmarshal(content, &info); err != nil {
+		return fmt.Errorf("failed to parse bundle info: %v", err)
+	}
+
+	switch {
+	case info.OS != d.Name():
+		return fmt.Errorf("bundle was made for %s, but this node runs %s", info.OS, d.Name())
+	case info.Arch != runtime.GOARCH:
+		return fmt.Errorf("bundle was made for %s, but this node is %s", info.Arch, runtime.GOARCH)
+	case info.KubernetesVersion != cfg.KubeVersion:
+		return fmt.Errorf("bundle has Kubernetes %s, but %s is to be installed", info.KubernetesVersion, cfg.KubeVersion)
+	}
+
+	images, err := bundleImages(cfg, manifestFiles)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, image := range images {
+		if !slices.Contains(info.Images, image) {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("bundle is missing %s, create it with the same settings as the install", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// importBundleImages loads the bundle's images where the kubelet finds them,
+// so nothing has to be pulled.
+func importBundleImages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	_, err := r.Run(fmt.Sprintf("ctr -n k8s.io images import %s", fsys.Path(filepath.Join(BundleDir, bundleImageArchive))))
+	return err
+}
@@ -0,0 +1,85 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/kube"
+	"go-install-kubernetes/pkg/wait"
+)
+
+// Embedded manifests for the CNIs other than Calico
+const (
+	flannelManifest = "manifests/flannel/kube-flannel.yaml.tmpl"
+	ciliumManifest  = "manifests/cilium/cilium.yaml.tmpl"
+)
+
+// cniStep returns the step that installs the selected pod network, or false
+// if none is wanted.
+func cniStep(cfg *config.Config) (step, bool) {
+	switch cfg.CNI {
+	case config.CNIFlannel:
+		return step{"Install Flannel CNI", installFlannelCNI}, true
+	case config.CNICilium:
+		return step{"Install Cilium CNI", installCiliumCNI}, true
+	case config.CNINone:
+		return step{}, false
+	default:
+		return step{"Install Calico CNI", installCalicoCNI}, true
+	}
+}
+
+// cniManifests returns the embedded manifests the selected pod network is
+// installed from, in the order they are applied.
+func cniManifests(cfg *config.Config) []string {
+	switch cfg.CNI {
+	case config.CNIFlannel:
+		return []string{flannelManifest}
+	case config.CNICilium:
+		return []string{ciliumManifest}
+	case config.CNINone:
+		return nil
+	default:
+		return []string{calicoOperatorManifest, calicoResourcesManifest}
+	}
+}
+
+func installFlannelCNI(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	if err := applyManifest(cfg, client, manifestFiles, flannelManifest); err != nil {
+		return err
+	}
+	return waitFor(cfg, "Flannel", wait.DaemonSetRolledOut(client, "kube-flannel", "kube-flannel-ds"))
+}
+
+func installCiliumCNI(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	if err := applyManifest(cfg, client, manifestFiles, ciliumManifest); err != nil {
+		return err
+	}
+
+	// The operator registers Cilium's CRDs, which the agents wait for
+	return waitFor(cfg, "Cilium", wait.All(
+		wait.DeploymentAvailable(client, "kube-system", "cilium-operator"),
+		wait.DaemonSetRolledOut(client, "kube-system", "cilium"),
+	))
+}
+
+// applyManifest renders an embedded manifest and applies it.
+func applyManifest(cfg *config.Config, client *kube.Client, manifestFiles fs.FS, path string) error {
+	manifest, err := RenderManifest(cfg, manifestFiles, path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return client.Apply(context.Background(), manifest)
+}
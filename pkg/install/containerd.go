@@ -0,0 +1,174 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+const containerdConfigFile = "/etc/containerd/config.toml"
+
+// containerdConfig is the part of containerd's config.toml the installer
+// sets, in the version 2 format of containerd 1.7. Anything left out keeps
+// containerd's built-in default.
+type containerdConfig struct {
+	Version int               `toml:"version"`
+	Root    string            `toml:"root"`
+	State   string            `toml:"state"`
+	Plugins containerdPlugins `toml:"plugins"`
+}
+
+type containerdPlugins struct {
+	CRI criConfig `toml:"io.containerd.grpc.v1.cri"`
+}
+
+type criConfig struct {
+	SandboxImage string        `toml:"sandbox_image"`
+	Containerd   criContainerd `toml:"containerd"`
+	Registry     criRegistry   `toml:"registry"`
+}
+
+type criContainerd struct {
+	Snapshotter        string                `toml:"snapshotter"`
+	DefaultRuntimeName string                `toml:"default_runtime_name"`
+	Runtimes           map[string]criRuntime `toml:"runtimes"`
+}
+
+type criRuntime struct {
+	RuntimeType string       `toml:"runtime_type"`
+	Options     *runcOptions `toml:"options,omitempty"`
+}
+
+// runcOptions are the options of the io.containerd.runc.v2 shim.
+type runcOptions struct {
+	BinaryName    string `toml:"BinaryName,omitempty"`
+	SystemdCgroup bool   `toml:"SystemdCgroup"`
+}
+
+type criRegistry struct {
+	ConfigPath string                    `toml:"config_path"`
+	Configs    map[string]registryConfig `toml:"configs,omitempty"`
+}
+
+type registryConfig struct {
+	Auth registryAuth `toml:"auth"`
+}
+
+type registryAuth struct {
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// pauseImages are the pause image tags kubeadm uses, by the minor release
+// that introduced each. Releases before the first use the first.
+var pauseImages = []struct {
+	minor int
+	tag   string
+}{
+	{27, "3.9"},
+	{31, "3.10"},
+}
+
+// sandboxImage returns the pause image containerd starts pods with. It
+// matches what kubeadm pulls, so kubeadm doesn't warn about it and a bundle
+// already holds it.
+func sandboxImage(cfg *config.Config) string {
+	if cfg.Containerd.SandboxImage != "" {
+		return cfg.Containerd.SandboxImage
+	}
+
+	tag := pauseImages[0].tag
+	if v, err := parseKubeVersion(cfg.KubeVersion); err == nil {
+		for _, p := range pauseImages {
+			if v.minor >= p.minor {
+				tag = p.tag
+			}
+		}
+	}
+
+	repository := "registry.k8s.io"
+	if cfg.ImageRepository != "" {
+		repository = cfg.ImageRepository
+	}
+	return fmt.Sprintf("%s/pause:%s", repository, tag)
+}
+
+// systemdCgroup reports whether runc should use the systemd cgroup driver.
+// kubeadm sets up the kubelet with it, so it is on unless the settings say
+// otherwise or the node wasn't booted with systemd.
+func systemdCgroup(cfg *config.Config, fsys hostfs.FS) bool {
+	if cfg.Containerd.SystemdCgroup != nil {
+		return *cfg.Containerd.SystemdCgroup
+	}
+	comm, err := fsys.ReadFile("/proc/1/comm")
+	if err != nil {
+		return true
+	}
+	return strings.TrimSpace(string(comm)) == "systemd"
+}
+
+// newContainerdConfig builds the containerd config for cfg.
+func newContainerdConfig(cfg *config.Config, systemd bool) containerdConfig {
+	runtimes := map[string]criRuntime{
+		"runc": {
+			RuntimeType: config.DefaultRuntimeType,
+			Options:     &runcOptions{SystemdCgroup: systemd},
+		},
+	}
+	for _, rt := range cfg.Containerd.Runtimes {
+		entry := criRuntime{RuntimeType: rt.Type}
+		if entry.RuntimeType == "" {
+			entry.RuntimeType = config.DefaultRuntimeType
+		}
+		// Other shims take options of their own, left at their defaults
+		if entry.RuntimeType == config.DefaultRuntimeType {
+			entry.Options = &runcOptions{BinaryName: rt.BinaryName, SystemdCgroup: systemd}
+		}
+		runtimes[rt.Name] = entry
+	}
+
+	return containerdConfig{
+		Version: 2,
+		Root:    cfg.Containerd.Root,
+		State:   "/run/containerd",
+		Plugins: containerdPlugins{
+			CRI: criConfig{
+				SandboxImage: sandboxImage(cfg),
+				Containerd: criContainerd{
+					Snapshotter:        cfg.Containerd.Snapshotter,
+					DefaultRuntimeName: "runc",
+					Runtimes:           runtimes,
+				},
+				Registry: newCRIRegistry(cfg),
+			},
+		},
+	}
+}
+
+// renderContainerdConfig encodes c as TOML and decodes it again, so that a
+// config containerd would read differently, or not at all, is never written.
+func renderContainerdConfig(c containerdConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, fmt.Errorf("failed to encode containerd config: %v", err)
+	}
+
+	var decoded containerdConfig
+	md, err := toml.Decode(buf.String(), &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse containerd config: %v", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("containerd config has unexpected keys %v", undecoded)
+	}
+	if !reflect.DeepEqual(decoded, c) {
+		return nil, fmt.Errorf("containerd config doesn't read back as written")
+	}
+	return buf.Bytes(), nil
+}
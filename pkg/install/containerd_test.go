@@ -0,0 +1,113 @@
+package install
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go-install-kubernetes/pkg/config"
+)
+
+func TestRenderContainerdConfig(t *testing.T) {
+	registries := []config.Registry{
+		{
+			Host:    "docker.io",
+			Mirrors: []string{"https://mirror.internal"},
+		},
+		{
+			Host:     "registry.internal:5000",
+			Username: "puller",
+			Password: `p"ss\word`,
+		},
+	}
+
+	runtimes := []config.ContainerdRuntime{
+		{Name: "crun", BinaryName: "/usr/bin/crun"},
+		{Name: "runsc", Type: "io.containerd.runsc.v1"},
+	}
+
+	tests := []struct {
+		name    string
+		systemd bool
+		setup   func(cfg *config.Config)
+	}{
+		{"systemd", true, nil},
+		{"cgroupfs", false, nil},
+		{"systemd-registries", true, func(cfg *config.Config) {
+			cfg.Registries = registries
+		}},
+		{"cgroupfs-registries", false, func(cfg *config.Config) {
+			cfg.Registries = registries
+		}},
+		{"sandbox-image", true, func(cfg *config.Config) {
+			cfg.Containerd.SandboxImage = "registry.internal:5000/pause:3.10"
+		}},
+		{"root", true, func(cfg *config.Config) {
+			cfg.Containerd.Root = "/data/containerd"
+		}},
+		{"snapshotter", true, func(cfg *config.Config) {
+			cfg.Containerd.Snapshotter = "zfs"
+		}},
+		{"runtimes", true, func(cfg *config.Config) {
+			cfg.Containerd.Runtimes = runtimes
+		}},
+		{"combined", false, func(cfg *config.Config) {
+			cfg.ImageRepository = "registry.internal:5000/k8s"
+			cfg.Containerd.Root = "/data/containerd"
+			cfg.Containerd.Snapshotter = "native"
+			cfg.Containerd.Runtimes = runtimes
+			cfg.Registries = registries
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+
+			got, err := renderContainerdConfig(newContainerdConfig(cfg, tt.systemd))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("containerd", tt.name+".toml"), got)
+		})
+	}
+}
+
+func TestSandboxImage(t *testing.T) {
+	tests := []struct {
+		kubeVersion string
+		repository  string
+		want        string
+	}{
+		{"1.26.0", "", "registry.k8s.io/pause:3.9"},
+		{"1.30.9", "", "registry.k8s.io/pause:3.9"},
+		{"1.31.5", "", "registry.k8s.io/pause:3.10"},
+		{"1.32", "registry.internal/k8s", "registry.internal/k8s/pause:3.10"},
+	}
+	for _, tt := range tests {
+		cfg := config.New()
+		cfg.KubeVersion = tt.kubeVersion
+		cfg.ImageRepository = tt.repository
+		if got := sandboxImage(cfg); got != tt.want {
+			t.Errorf("sandboxImage(%s, %q) = %s, want %s", tt.kubeVersion, tt.repository, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		reg    config.Registry
+		caPath string
+	}{
+		{"docker-hub-mirrors", config.Registry{Host: "docker.io", Mirrors: []string{"https://mirror-a.internal/", "http://mirror-b.internal:5000"}}, ""},
+		{"private-ca", config.Registry{Host: "registry.internal:5000", Username: "puller", Password: "secret"}, "/etc/containerd/certs.d/registry.internal:5000/ca.crt"},
+		{"insecure-http", config.Registry{Host: "registry.lab", Server: "http://registry.lab", Insecure: true, Mirrors: []string{"http://cache.lab"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGolden(t, filepath.Join("registries", tt.name+".toml"), []byte(registryHosts(tt.reg, tt.caPath)))
+		})
+	}
+}
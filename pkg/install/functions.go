@@ -1,162 +1,223 @@
 package install
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"net"
+	"slices"
 	"strings"
 
 	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/distro"
 	"go-install-kubernetes/pkg/exec"
-
-	"github.com/bitfield/script"
+	"go-install-kubernetes/pkg/hostfs"
 )
 
-func checkUbuntuVersion(cfg *config.Config) error {
-	version, err := script.File("/etc/lsb-release").Match("DISTRIB_RELEASE").String()
+// nodeName returns the name this node is registered under, which kubeadm
+// takes from the host name by default.
+func nodeName(cfg *config.Config, r exec.Runner) (string, error) {
+	res, err := r.Run("hostname")
 	if err != nil {
-		return err
+		return "", err
 	}
-	if !strings.Contains(version, config.UbuntuVersion) {
-		return fmt.Errorf("this script only works on Ubuntu %s", config.UbuntuVersion)
+	name := strings.ToLower(strings.TrimSpace(res.Stdout))
+	if name == "" && cfg.DryRun {
+		name = "<node-name>"
 	}
-	return nil
+	return name, nil
+}
+
+// detectDistro returns the Distro named by the os setting, or else the one
+// the host's os-release file names.
+func detectDistro(cfg *config.Config, fsys hostfs.FS) (distro.Distro, error) {
+	if cfg.OS != "" {
+		return distro.Named(cfg.OS)
+	}
+	d, err := distro.Detect(fsys)
+	if err != nil && cfg.Root != "" {
+		return nil, fmt.Errorf("%v, use --os to name the release", err)
+	}
+	return d, err
 }
 
-func disableSwap(cfg *config.Config) error {
-	if _, err := exec.Command("swapoff -a", cfg); err != nil {
+// checkOperatingSystem makes sure the host runs a supported release, which
+// every later step relies on.
+func checkOperatingSystem(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
 		return err
 	}
-	_, err := script.File("/etc/fstab").
-		FilterLine(func(line string) string {
-			if strings.Contains(line, " swap ") {
-				return "#" + line
-			}
-			return line
-		}).
-		WriteFile("/etc/fstab")
-	return err
+	fmt.Printf("Detected %s\n", d.Name())
+	return nil
 }
 
-func removePackages(cfg *config.Config) error {
-	cmds := []string{
-		"apt-mark unhold kubelet kubeadm kubectl kubernetes-cni",
-		"apt-get remove -y moby-buildx moby-cli moby-compose moby-containerd moby-engine moby-runc",
-		"apt-get autoremove -y",
-		"apt-get remove -y docker.io containerd kubelet kubeadm kubectl",
-		"systemctl daemon-reload",
+// checkNetworkRanges makes sure the pod and service subnets don't collide
+// with anything the node can already reach.
+func checkNetworkRanges(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	res, err := r.Run("ip -o route show")
+	if err != nil {
+		return err
 	}
-	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
-			// Ignore errors as some packages might not exist
+
+	subnets := []struct {
+		name string
+		cidr string
+	}{
+		{"pod subnet", cfg.PodSubnet},
+		{"service subnet", cfg.ServiceSubnet},
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(res.Stdout), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "default" || isCNIRoute(fields) {
+			continue
+		}
+
+		dest := fields[0]
+		if !strings.Contains(dest, "/") {
+			dest += "/32"
+		}
+		_, route, err := net.ParseCIDR(dest)
+		if err != nil {
 			continue
 		}
+
+		for _, subnet := range subnets {
+			_, network, err := net.ParseCIDR(subnet.cidr)
+			if err != nil {
+				return err
+			}
+			if config.Overlaps(network, route) {
+				return fmt.Errorf("%s %s overlaps existing route %s", subnet.name, subnet.cidr, fields[0])
+			}
+		}
 	}
 	return nil
 }
 
-func installPackages(cfg *config.Config) error {
-	if _, err := exec.Command("apt-get update", cfg); err != nil {
-		return err
+// isCNIRoute reports whether a route was added by a CNI plugin, which is
+// expected to sit inside the pod subnet when re-running on a cluster node.
+func isCNIRoute(fields []string) bool {
+	switch fields[0] {
+	case "blackhole", "unreachable", "prohibit":
+		return true
 	}
-
-	packages := []string{
-		"apt-transport-https",
-		"ca-certificates",
-		"curl",
-		"gnupg",
-		"lsb-release",
-		"software-properties-common",
-		"wget",
-		"jq",
+	for i, field := range fields {
+		if field != "dev" || i+1 >= len(fields) {
+			continue
+		}
+		for _, prefix := range []string{"cali", "tunl", "vxlan.calico", "flannel", "cni", "cilium"} {
+			if strings.HasPrefix(fields[i+1], prefix) {
+				return true
+			}
+		}
 	}
-	installCmd := fmt.Sprintf("apt-get install -y %s", strings.Join(packages, " "))
-	_, err := exec.Command(installCmd, cfg)
-	return err
+	return false
 }
 
-func installContainerd(cfg *config.Config) error {
-	cmds := []string{
-		"apt-get update",
-		"apt-get install -y containerd",
+func disableSwap(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if _, err := r.Run("swapoff -a"); err != nil {
+		return err
 	}
-	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
-			return err
+	content, err := fsys.ReadFile("/etc/fstab")
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing mounts swap at boot
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if strings.Contains(line, " swap ") {
+			lines[i] = "#" + line
 		}
 	}
-	return nil
+	return fsys.WriteFile("/etc/fstab", []byte(strings.Join(lines, "\n")), 0644)
 }
 
-func installKubernetesPackages(cfg *config.Config) error {
-	// Extract major version (1.29 from 1.29.0)
-	kubeRepoVersion := strings.Join(strings.Split(config.KubeVersion, ".")[:2], ".")
+// kubePackages are held at the installed version so that a system upgrade
+// can't move them ahead of the control plane.
+var kubePackages = []string{"kubelet", "kubeadm", "kubectl"}
 
-	// Remove old repo file and GPG key if they exist
-	os.Remove("/etc/apt/sources.list.d/kubernetes.list")
-	os.Remove("/etc/apt/keyrings/kubernetes-apt-keyring.gpg")
-
-	// Create keyrings directory if it doesn't exist
-	if err := os.MkdirAll("/etc/apt/keyrings", 0755); err != nil {
+func removePackages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := hostDistro(cfg, fsys)
+	if err != nil {
 		return err
 	}
 
-	// Download and install GPG key
-	gpgKeyURL := fmt.Sprintf("https://pkgs.k8s.io/core:/stable:/v%s/deb/Release.key", kubeRepoVersion)
+	// Ignore errors as some packages might not exist
+	d.Unhold(r, append(kubePackages, "kubernetes-cni")...)
+	d.Remove(r, d.ConflictingPackages()...)
+	d.Autoremove(r)
+	d.Remove(r, append([]string{d.ContainerdPackage()}, kubePackages...)...)
+	_, err = r.Run("systemctl daemon-reload")
+	return err
+}
 
-	// Create secure temporary directory
-	tmpDir, err := os.MkdirTemp("", "k8s-gpg-*")
+func installPackages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := hostDistro(cfg, fsys)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
+		return err
 	}
-	defer os.RemoveAll(tmpDir)
+	if err := d.Refresh(r); err != nil {
+		return err
+	}
+	return d.Install(r, d.BasePackages()...)
+}
 
-	// Set secure permissions
-	if err := os.Chmod(tmpDir, 0700); err != nil {
-		return fmt.Errorf("failed to set permissions on temp directory: %v", err)
+func installContainerd(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if cfg.ContainerdSource == config.ContainerdSourceRelease {
+		return installContainerdRelease(cfg, r, fsys)
 	}
 
-	keyPath := filepath.Join(tmpDir, "k8s-key.gpg")
-	if _, err := exec.Command(fmt.Sprintf("curl -fsSLo %s %s", keyPath, gpgKeyURL), cfg); err != nil {
+	d, err := hostDistro(cfg, fsys)
+	if err != nil {
 		return err
 	}
-
-	if _, err := exec.Command(fmt.Sprintf("gpg --dearmor --yes -o /etc/apt/keyrings/kubernetes-apt-keyring.gpg %s", keyPath), cfg); err != nil {
+	if err := d.AddContainerdRepo(r, fsys); err != nil {
 		return err
 	}
+	if err := d.Refresh(r); err != nil {
+		return err
+	}
+	return d.Install(r, d.ContainerdPackage())
+}
 
-	// Clean up temp file
-	os.Remove(keyPath)
-
-	// Add new repo
-	repoContent := fmt.Sprintf("deb [signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] https://pkgs.k8s.io/core:/stable:/v%s/deb/ /", kubeRepoVersion)
-	if err := os.WriteFile("/etc/apt/sources.list.d/kubernetes.list", []byte(repoContent), 0644); err != nil {
+func installKubernetesPackages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := hostDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	if err := d.AddKubernetesRepo(r, fsys, cfg.KubeVersion); err != nil {
+		return err
+	}
+	if err := d.Refresh(r); err != nil {
 		return err
 	}
 
-	cmds := []string{
-		"apt-get update",
-		fmt.Sprintf("apt-get install -y --allow-downgrades kubelet=%s-* kubeadm=%s-* kubectl=%s-*", config.KubeVersion, config.KubeVersion, config.KubeVersion),
-		"apt-mark hold kubelet kubeadm kubectl",
+	var pinned []string
+	for _, pkg := range kubePackages {
+		pinned = append(pinned, d.Pin(pkg, cfg.KubeVersion))
 	}
-	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
-			return err
-		}
+	if err := d.Install(r, pinned...); err != nil {
+		return err
 	}
-	return nil
+	return d.Hold(r, kubePackages...)
 }
 
-func configureSystem(cfg *config.Config) error {
+func configureSystem(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
 	modulesContent := "overlay\nbr_netfilter\n"
-	if err := os.WriteFile("/etc/modules-load.d/containerd.conf", []byte(modulesContent), 0644); err != nil {
+	if err := fsys.WriteFile("/etc/modules-load.d/containerd.conf", []byte(modulesContent), 0644); err != nil {
 		return err
 	}
 
 	sysctlContent := `net.bridge.bridge-nf-call-iptables  = 1
 net.ipv4.ip_forward                 = 1
 net.bridge.bridge-nf-call-ip6tables = 1`
-	if err := os.WriteFile("/etc/sysctl.d/99-kubernetes-cri.conf", []byte(sysctlContent), 0644); err != nil {
+	if err := fsys.WriteFile("/etc/sysctl.d/99-kubernetes-cri.conf", []byte(sysctlContent), 0644); err != nil {
 		return err
 	}
 
@@ -166,66 +227,83 @@ net.bridge.bridge-nf-call-ip6tables = 1`
 		"sysctl --system",
 	}
 	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
+		if _, err := r.Run(cmd); err != nil {
 			return err
 		}
 	}
-	return nil
+
+	d, err := hostDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	return d.ConfigureSystem(r, fsys, distro.SystemOptions{
+		SELinuxPermissive: cfg.SELinuxPermissive,
+		FirewallPorts:     firewallPorts(cfg),
+		TrustedSources:    []string{cfg.PodSubnet, cfg.ServiceSubnet},
+	})
+}
+
+// Ports the node must accept connections on, as port/protocol. Every node
+// runs the kubelet and kube-proxy and serves the NodePort range.
+var (
+	nodePorts         = []string{"10250/tcp", "10256/tcp", "30000-32767/tcp", "30000-32767/udp"}
+	controlPlanePorts = []string{"6443/tcp", "2379-2380/tcp", "10257/tcp", "10259/tcp"}
+	cniPorts          = map[string][]string{
+		// BGP, VXLAN and Typha
+		config.CNICalico:  {"179/tcp", "4789/udp", "5473/tcp"},
+		config.CNIFlannel: {"8472/udp"},
+		// VXLAN and health checks
+		config.CNICilium: {"8472/udp", "4240/tcp"},
+	}
+)
+
+// firewallPorts returns the ports the node's role and the pod network need
+// opened.
+func firewallPorts(cfg *config.Config) []string {
+	ports := slices.Clone(nodePorts)
+	if cfg.IsControlNode || cfg.IsSingleNode {
+		ports = append(ports, controlPlanePorts...)
+	}
+	return append(ports, cniPorts[cfg.CNI]...)
 }
 
-func configureCrictl(cfg *config.Config) error {
+func configureCrictl(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
 	content := "runtime-endpoint: unix:///run/containerd/containerd.sock\n"
-	return os.WriteFile("/etc/crictl.yaml", []byte(content), 0644)
+	return fsys.WriteFile("/etc/crictl.yaml", []byte(content), 0644)
 }
 
-func configureKubelet(cfg *config.Config) error {
+func configureKubelet(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := hostDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
 	content := "KUBELET_EXTRA_ARGS=\"--container-runtime-endpoint unix:///run/containerd/containerd.sock\"\n"
-	return os.WriteFile("/etc/default/kubelet", []byte(content), 0644)
+	return fsys.WriteFile(d.KubeletEnvFile(), []byte(content), 0644)
 }
 
-func configureContainerd(cfg *config.Config) error {
-	if err := os.MkdirAll("/etc/containerd", 0755); err != nil {
+func configureContainerd(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if err := fsys.MkdirAll("/etc/containerd", 0755); err != nil {
 		return err
 	}
 
-	configContent := `disabled_plugins = []
-imports = []
-oom_score = 0
-plugin_dir = ""
-required_plugins = []
-root = "/var/lib/containerd"
-state = "/run/containerd"
-version = 2
-
-[plugins]
-
-  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes]
-    [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc]
-      base_runtime_spec = ""
-      container_annotations = []
-      pod_annotations = [] 
-      privileged_without_host_devices = false
-      runtime_engine = ""
-      runtime_root = ""
-      runtime_type = "io.containerd.runc.v2"
-
-      [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc.options]
-        BinaryName = ""
-        CriuImagePath = ""
-        CriuPath = ""
-        CriuWorkPath = ""
-        IoGid = 0
-        IoUid = 0
-        NoNewKeyring = false
-        NoPivotRoot = false
-        Root = ""
-        ShimCgroup = ""
-        SystemdCgroup = true`
+	content, err := renderContainerdConfig(newContainerdConfig(cfg, systemdCgroup(cfg, fsys)))
+	if err != nil {
+		return err
+	}
 
-	return os.WriteFile("/etc/containerd/config.toml", []byte(configContent), 0644)
+	// Only root should read the registry passwords. The package installs
+	// the file first, and writing it keeps its mode.
+	perm := fs.FileMode(0644)
+	if hasRegistryLogins(cfg) {
+		perm = 0600
+	}
+	if err := fsys.WriteFile(containerdConfigFile, content, perm); err != nil {
+		return err
+	}
+	return fsys.Chmod(containerdConfigFile, perm)
 }
 
-func startServices(cfg *config.Config) error {
+func startServices(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
 	cmds := []string{
 		"systemctl daemon-reload",
 		"systemctl enable containerd",
@@ -234,7 +312,7 @@ func startServices(cfg *config.Config) error {
 		"systemctl start kubelet",
 	}
 	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
+		if _, err := r.Run(cmd); err != nil {
 			return err
 		}
 	}
@@ -3,89 +3,168 @@ package install
 import (
 	"fmt"
 	"io/fs"
+	"slices"
 
 	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
 )
 
-func Kubernetes(cfg *config.Config, manifestFiles fs.FS) error {
+type step struct {
+	name string
+	fn   func(*config.Config, exec.Runner, hostfs.FS, fs.FS) error
+}
+
+func Kubernetes(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
 	// Log the configuration
 	if cfg.IsVerbose {
 		fmt.Printf("Configuration:\n"+
 			"Control Node: %v\n"+
 			"Worker Node: %v\n"+
 			"Single Node: %v\n"+
-			"Log File: %s\n",
+			"Log File: %s\n"+
+			"Root: %s\n"+
+			"Kubernetes Version: %s\n",
 			cfg.IsControlNode, cfg.IsWorkerNode,
-			cfg.IsSingleNode, cfg.LogFile)
+			cfg.IsSingleNode, cfg.LogFile, cfg.Root, cfg.KubeVersion)
+	}
+
+	if err := ValidateAddons(cfg.Addons); err != nil {
+		return err
 	}
 
-	steps := []struct {
-		name string
-		fn   func(*config.Config) error
-	}{
-		{"Check Ubuntu version", checkUbuntuVersion},
-		{"Disable swap", disableSwap},
-		{"Remove existing packages", removePackages},
-		{"Install required packages", installPackages},
-		{"Install containerd", installContainerd},
-		{"Install Kubernetes packages", installKubernetesPackages},
-		{"Configure system", configureSystem},
-		{"Configure crictl", configureCrictl},
-		{"Configure kubelet", configureKubelet},
-		{"Configure containerd", configureContainerd},
-		{"Start services", startServices},
+	state, err := loadState(cfg, fsys)
+	if err != nil {
+		return err
 	}
 
-	for _, step := range steps {
+	for _, step := range installSteps(cfg) {
+		if state.isCompleted(step.name) {
+			fmt.Printf("Skipping: %s (already completed)\n", step.name)
+			continue
+		}
+
 		fmt.Printf("Executing: %s...\n", step.name)
-		if err := step.fn(cfg); err != nil {
+		if err := step.fn(cfg, r, fsys, manifestFiles); err != nil {
+			state.fail(step.name, err)
+			if saveErr := state.save(cfg, fsys); saveErr != nil {
+				fmt.Printf("Failed to save install state: %v\n", saveErr)
+			}
 			return fmt.Errorf("%s failed: %v", step.name, err)
 		}
+
+		state.complete(step.name)
+		if err := state.save(cfg, fsys); err != nil {
+			return fmt.Errorf("failed to save install state: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// installSteps returns every step for the node's role, in order. Step names
+// are recorded in the state file, so renaming one makes --resume rerun it.
+func installSteps(cfg *config.Config) []step {
+	steps := []step{
+		{"Check operating system", hostStep(checkOperatingSystem)},
+	}
+	if cfg.BundlePath != "" {
+		steps = append(steps, step{"Extract bundle", extractBundle})
+	}
+	// The ranges are only chosen by kubeadm init, other nodes take the
+	// cluster's
+	if (cfg.IsControlNode || cfg.IsSingleNode) && !cfg.JoinControlPlane {
+		steps = append(steps, step{"Check network ranges", hostStep(checkNetworkRanges)})
+	}
+	steps = append(steps, []step{
+		{"Disable swap", hostStep(disableSwap)},
+		{"Remove existing packages", hostStep(removePackages)},
+		{"Install required packages", hostStep(installPackages)},
+		{"Install containerd", hostStep(installContainerd)},
+		{"Install Kubernetes packages", hostStep(installKubernetesPackages)},
+	}...)
+	if cfg.ContainerdSource == config.ContainerdSourceRelease {
+		steps = append(steps, step{"Install CNI plugins", hostStep(installCNIPlugins)})
+	}
+	steps = append(steps, []step{
+		{"Configure system", hostStep(configureSystem)},
+		{"Configure crictl", hostStep(configureCrictl)},
+		{"Configure kubelet", hostStep(configureKubelet)},
+		{"Configure containerd", hostStep(configureContainerd)},
+		{"Configure registries", hostStep(configureRegistries)},
+		{"Start services", hostStep(startServices)},
+		{"Check containerd version", hostStep(checkContainerdVersion)},
+	}...)
+	if cfg.BundlePath != "" {
+		steps = append(steps, step{"Import bundle images", hostStep(importBundleImages)})
+	}
+
+	// Additional control plane nodes get everything from the existing cluster
+	if cfg.JoinControlPlane {
+		// kubeadm join refuses a manifests directory that isn't empty, and
+		// the VIP is already announced by the first control plane node
+		steps = append(steps, step{"Join cluster", hostStep(joinCluster)})
+		if cfg.KubeVIP != "" {
+			steps = append(steps, step{"Install kube-vip", installKubeVIP})
+		}
+		steps = append(steps, step{"Configure kubeconfig", hostStep(configureKubeconfig)})
+		if cfg.CNI != config.CNINone {
+			steps = append(steps, step{"Wait for nodes", hostStep(waitForNodes)})
+		}
+		return steps
 	}
 
 	// Control plane specific steps
 	if cfg.IsControlNode || cfg.IsSingleNode {
-		controlPlaneSteps := []struct {
-			name string
-			fn   func(*config.Config, fs.FS) error
-		}{
-			{"Initialize control plane", func(cfg *config.Config, _ fs.FS) error { return kubeadmInit(cfg) }},
-			{"Configure kubeconfig", func(cfg *config.Config, _ fs.FS) error { return configureKubeconfig(cfg) }},
-			{"Install Calico CNI", installCalicoCNI},
-			{"Wait for nodes", func(cfg *config.Config, _ fs.FS) error { return waitForNodes(cfg) }},
-			{"Test Kubernetes version", func(cfg *config.Config, _ fs.FS) error { return testKubernetesVersion(cfg) }},
-			{"Install metrics server", installMetricsServer},
+		if cfg.KubeVIP != "" {
+			steps = append(steps, step{"Install kube-vip", installKubeVIP})
 		}
+		steps = append(steps, step{"Initialize control plane", hostStep(kubeadmInit)})
+		if cfg.KubeVIP != "" {
+			steps = append(steps, step{"Switch kube-vip to admin.conf", hostStep(switchKubeVIPToAdminConf)})
+		}
+		steps = append(steps, step{"Configure kubeconfig", hostStep(configureKubeconfig)})
 
-		for _, step := range controlPlaneSteps {
-			fmt.Printf("Executing: %s...\n", step.name)
-			if err := step.fn(cfg, manifestFiles); err != nil {
-				return fmt.Errorf("%s failed: %v", step.name, err)
-			}
+		// Without a pod network the nodes stay NotReady and pods can't start,
+		// so skip the steps that wait on them
+		cni, ok := cniStep(cfg)
+		if ok {
+			steps = append(steps, cni, step{"Wait for nodes", hostStep(waitForNodes)})
 		}
+		steps = append(steps, step{"Test Kubernetes version", hostStep(testKubernetesVersion)})
 
+		// A single node has to be untainted before the addons can run on it
 		if cfg.IsSingleNode {
-			singleNodeSteps := []struct {
-				name string
-				fn   func(*config.Config) error
-			}{
-				{"Configure as single node", configureAsSingleNode},
-				{"Test nginx pod", testNginxPod},
-				{"Wait for pods running", waitForPodsRunning},
-			}
+			steps = append(steps, step{"Configure as single node", hostStep(configureAsSingleNode)})
+		}
+		steps = append(steps, addonSteps(cfg)...)
 
-			for _, step := range singleNodeSteps {
-				fmt.Printf("Executing: %s...\n", step.name)
-				if err := step.fn(cfg); err != nil {
-					return fmt.Errorf("%s failed: %v", step.name, err)
-				}
+		if cfg.IsSingleNode && ok {
+			steps = append(steps,
+				step{"Test nginx pod", hostStep(testNginxPod)},
+			)
+			if slices.Contains(cfg.Addons, LocalPathProvisioner) {
+				steps = append(steps, step{"Test local-path storage", hostStep(testLocalPathStorage)})
 			}
+			steps = append(steps, step{"Wait for pods running", hostStep(waitForPodsRunning)})
 		}
 	} else {
-		if err := checkWorkerServices(cfg); err != nil {
-			return fmt.Errorf("worker services check failed: %v", err)
+		steps = append(steps, step{"Check worker services", hostStep(checkWorkerServices)})
+
+		if cfg.JoinEndpoint != "" || cfg.JoinServer != "" {
+			steps = append(steps,
+				step{"Join cluster", hostStep(joinCluster)},
+				step{"Wait for node registration", hostStep(waitForNodeRegistration)},
+			)
 		}
 	}
 
-	return nil
+	return steps
+}
+
+// hostStep adapts a step that doesn't need the embedded manifests.
+func hostStep(fn func(*config.Config, exec.Runner, hostfs.FS) error) func(*config.Config, exec.Runner, hostfs.FS, fs.FS) error {
+	return func(cfg *config.Config, r exec.Runner, fsys hostfs.FS, _ fs.FS) error {
+		return fn(cfg, r, fsys)
+	}
 }
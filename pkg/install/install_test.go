@@ -0,0 +1,224 @@
+package install
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file, got:\n%s", path, got)
+	}
+}
+
+// captureStdout returns what fn prints, which is where the steps report
+// their progress and a dry run its plan.
+func captureStdout(t *testing.T, fn func(w io.Writer) error) ([]byte, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.Bytes()
+	}()
+	runErr := fn(w)
+	w.Close()
+	return <-out, runErr
+}
+
+// TestRenderRoot plans installs against the host files in testdata/roots,
+// the way --dry-run --root does, and compares the plan with a golden file.
+func TestRenderRoot(t *testing.T) {
+	tests := []struct {
+		name  string
+		root  string
+		setup func(cfg *config.Config)
+	}{
+		{
+			name: "ubuntu-single",
+			root: "ubuntu-24.04",
+			setup: func(cfg *config.Config) {
+				cfg.IsSingleNode = true
+				cfg.KubeconfigUser = "ubuntu"
+			},
+		},
+		{
+			name: "rocky-control-plane",
+			root: "rocky-9.4",
+			setup: func(cfg *config.Config) {
+				cfg.IsControlNode = true
+				cfg.KubeVIP = "10.0.0.100"
+				cfg.KubeVIPInterface = "eth0"
+				cfg.CNI = config.CNIFlannel
+				cfg.ContainerdSource = config.ContainerdSourceRelease
+				cfg.SELinuxPermissive = true
+			},
+		},
+		{
+			name: "debian-worker",
+			root: "empty",
+			setup: func(cfg *config.Config) {
+				cfg.IsWorkerNode = true
+				cfg.OS = "debian-12"
+				cfg.JoinEndpoint = "10.0.0.100:6443"
+				cfg.JoinToken = "abcdef.0123456789abcdef"
+				cfg.JoinCAHash = "sha256:" + strings.Repeat("0", 64)
+			},
+		},
+	}
+	// Only the settings say who gets a copy of the kubeconfig
+	t.Setenv("SUDO_USER", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join("testdata", "roots", tt.root)
+			if tt.root == "empty" {
+				root = t.TempDir()
+			}
+			cfg := config.New()
+			cfg.DryRun = true
+			cfg.Root = root
+			tt.setup(cfg)
+
+			out, err := captureStdout(t, func(w io.Writer) error {
+				fsys := hostfs.NewDryRun(hostfs.New(root), w)
+				return Kubernetes(cfg, exec.NewDryRunner(w), fsys, manifestFiles)
+			})
+			if err != nil {
+				t.Fatalf("%v, output:\n%s", err, out)
+			}
+			out = bytes.ReplaceAll(out, []byte(root), []byte("<root>"))
+			checkGolden(t, filepath.Join("render", tt.name+".txt"), out)
+		})
+	}
+}
+
+func TestDisableSwapWithoutFstab(t *testing.T) {
+	r := exec.NewRecorder()
+	if err := disableSwap(config.New(), r, hostfs.New(t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Expect("swapoff -a"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDetectDistro(t *testing.T) {
+	cfg := config.New()
+	cfg.Root = t.TempDir()
+	fsys := hostfs.New(cfg.Root)
+
+	if _, err := detectDistro(cfg, fsys); err == nil || !strings.Contains(err.Error(), "use --os") {
+		t.Errorf("expected a hint to use --os, got %v", err)
+	}
+
+	cfg.OS = "rocky-9.4"
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name() != "Rocky Linux 9" {
+		t.Errorf("got %s, want Rocky Linux 9", d.Name())
+	}
+}
+
+func TestInputsHash(t *testing.T) {
+	hash := func(setup func(cfg *config.Config)) string {
+		t.Helper()
+		cfg := config.New()
+		cfg.IsWorkerNode = true
+		cfg.Registries = []config.Registry{{Host: "registry.internal", Username: "ci", Password: "first"}}
+		setup(cfg)
+		h, err := inputsHash(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash(func(cfg *config.Config) {})
+
+	// Secrets don't change the hash
+	secrets := hash(func(cfg *config.Config) {
+		cfg.JoinToken = "abcdef.0123456789abcdef"
+		cfg.JoinPSK = "a pre-shared key"
+		cfg.CertificateKey = strings.Repeat("0", 64)
+		cfg.Registries[0].Password = "second"
+	})
+	if secrets != base {
+		t.Errorf("hash changed with the secrets")
+	}
+
+	if hash(func(cfg *config.Config) { cfg.Registries[0].Username = "other" }) == base {
+		t.Errorf("hash didn't change with the registry username")
+	}
+	if hash(func(cfg *config.Config) { cfg.JoinEndpoint = "10.0.0.100:6443" }) == base {
+		t.Errorf("hash didn't change with the join endpoint")
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	root := t.TempDir()
+	fsys := hostfs.New(root)
+	// A directory and file left by an older run are made private
+	if err := fsys.WriteFile(StateFile, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Dir(fsys.Path(StateFile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadState(config.New(), fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(config.New(), fsys); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{
+		filepath.Dir(StateFile): 0700,
+		StateFile:               0600,
+	} {
+		info, err := os.Stat(fsys.Path(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s has mode %o, want %o", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package install
+
+import (
+	"fmt"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/joinserver"
+	"go-install-kubernetes/pkg/wait"
+)
+
+// Known kubeadm join failures, matched against its output, and what they
+// usually mean.
+var joinFailures = []struct {
+	match  string
+	reason string
+}{
+	{"invalid for this cluster or it has expired", "the token is unknown or has expired, create a new one on the control plane"},
+	{"couldn't validate the identity of the API Server", "the CA cert hash doesn't match the cluster, check --discovery-token-ca-cert-hash"},
+	{"none of the public keys", "the CA cert hash doesn't match the cluster, check --discovery-token-ca-cert-hash"},
+	{"connection refused", "nothing is listening on the join endpoint, check the address and that the API server is up"},
+	{"no route to host", "the join endpoint can't be reached from this node, check routing and firewalls"},
+	{"i/o timeout", "the join endpoint didn't answer, check the address and firewalls"},
+	{"FileAvailable--etc-kubernetes-kubelet.conf", "this node has already joined a cluster, run reset first"},
+	{"Port-10250", "something is already using the kubelet port 10250"},
+	{"x509", "the API server certificate could not be verified"},
+	{"stable controlPlaneEndpoint", "the cluster was installed without --control-plane-endpoint, so it can't have more control plane nodes"},
+	{"kubeadm-certs", "the certificate key is wrong or the upload has expired, run token upload-certs on a control plane node"},
+}
+
+// explainJoinError returns a human readable reason for a failed join, or an
+// empty string if the output doesn't match anything known.
+func explainJoinError(output string) string {
+	for _, failure := range joinFailures {
+		if strings.Contains(output, failure.match) {
+			return failure.reason
+		}
+	}
+	return ""
+}
+
+func joinCluster(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	// Tokens from a join server are short lived, so fetch one right before use
+	if cfg.JoinServer != "" {
+		if err := fetchJoinDetails(cfg); err != nil {
+			return err
+		}
+	}
+
+	cmd := fmt.Sprintf("kubeadm join %s --token %s --discovery-token-ca-cert-hash %s",
+		cfg.JoinEndpoint, cfg.JoinToken, cfg.JoinCAHash)
+	if cfg.JoinControlPlane {
+		cmd += fmt.Sprintf(" --control-plane --certificate-key %s", cfg.CertificateKey)
+	}
+
+	res, err := r.Run(cmd)
+	if err != nil {
+		if reason := explainJoinError(res.Stdout + res.Stderr); reason != "" {
+			return fmt.Errorf("%v: %s", err, reason)
+		}
+		return err
+	}
+	return nil
+}
+
+func fetchJoinDetails(cfg *config.Config) error {
+	// Asking the server creates a token on the cluster, so don't in a dry run
+	if cfg.DryRun {
+		cfg.JoinEndpoint = "<endpoint>"
+		cfg.JoinToken = "<token>"
+		cfg.JoinCAHash = "<ca-cert-hash>"
+		if cfg.JoinControlPlane {
+			cfg.CertificateKey = "<certificate-key>"
+		}
+		return nil
+	}
+
+	fmt.Printf("Fetching join details from %s...\n", cfg.JoinServer)
+	info, err := joinserver.Fetch(cfg.JoinServer, cfg.JoinPSK, cfg.JoinControlPlane, nil)
+	if err != nil {
+		return err
+	}
+	cfg.JoinEndpoint = info.Endpoint
+	cfg.JoinToken = info.Token
+	cfg.JoinCAHash = info.CAHash
+	cfg.CertificateKey = info.CertificateKey
+	return nil
+}
+
+// waitForNodeRegistration waits until the API server knows about this node,
+// using the kubelet's own credentials since workers have no admin config.
+func waitForNodeRegistration(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	name, err := nodeName(cfg, r)
+	if err != nil {
+		return err
+	}
+	client, err := newKubeClient(cfg, fsys, kubeletKubeconfig)
+	if err != nil {
+		return err
+	}
+
+	err = waitFor(cfg, "node "+name+" to register", wait.NodeRegistered(client, name))
+	if err == nil && !cfg.DryRun {
+		fmt.Printf("Node %s has registered with the cluster\n", name)
+	}
+	return err
+}
@@ -1,25 +1,92 @@
 package install
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"go-install-kubernetes/pkg/config"
 	"go-install-kubernetes/pkg/exec"
-	"io/fs"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/kube"
+	"go-install-kubernetes/pkg/wait"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Kubeconfigs on the node for talking to the cluster.
+const (
+	adminKubeconfig   = "/etc/kubernetes/admin.conf"
+	kubeletKubeconfig = "/etc/kubernetes/kubelet.conf"
+)
+
+const controlPlaneTaint = "node-role.kubernetes.io/control-plane"
+
+// Images for the test pods. They are tagged so the kubelet only pulls them
+// when missing, which lets a bundle supply them.
+const (
+	nginxImage   = "nginx:1.27"
+	busyboxImage = "busybox:1.37"
+)
+
+// Embedded manifests applied to the cluster once the control plane is up
+const (
+	calicoOperatorManifest  = "manifests/calico/tigera-operator.yaml.tmpl"
+	calicoResourcesManifest = "manifests/calico/custom-resources.yaml.tmpl"
 )
 
-func kubeadmInit(cfg *config.Config) error {
-	output, err := exec.Command("ip route get 1", cfg)
+var calicoInstallations = schema.GroupVersionResource{Group: "operator.tigera.io", Version: "v1", Resource: "installations"}
+
+// waitBackoff is how often the steps poll the cluster, overridable so the
+// steps can be exercised offline without waiting on a real cluster.
+var waitBackoff = wait.DefaultBackoff
+
+// newKubeClient connects to the cluster with a kubeconfig on the node. It is
+// a variable so the steps can be run against a fake clientset; a dry run
+// gets a client that prints the changes instead.
+var newKubeClient = func(cfg *config.Config, fsys hostfs.FS, kubeconfig string) (*kube.Client, error) {
+	if cfg.DryRun {
+		return kube.NewDryRun(os.Stdout), nil
+	}
+	return kube.NewFromKubeconfig(fsys.Path(kubeconfig))
+}
+
+// waitFor waits up to the kubectl timeout for cond. In a dry run nothing was
+// created, so the wait is only printed and taken as met.
+func waitFor(cfg *config.Config, what string, cond wait.Condition) error {
+	if cfg.DryRun {
+		fmt.Printf("  wait:  %s\n", what)
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(cfg.KubectlTimeout)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Printf("Waiting for %s...\n", what)
+	return wait.For(ctx, what, waitBackoff, cond)
+}
+
+func kubeadmInit(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	res, err := r.Run("ip route get 1")
 	if err != nil {
 		return err
 	}
 
 	// Parse the IP address from the output
-	fields := strings.Fields(output)
+	fields := strings.Fields(res.Stdout)
 	var mainIP string
 	for i, field := range fields {
 		if field == "src" && i+1 < len(fields) {
@@ -28,241 +95,391 @@ func kubeadmInit(cfg *config.Config) error {
 		}
 	}
 
+	// Nothing was really run in a dry run, so there is no address to parse
+	if mainIP == "" && cfg.DryRun {
+		mainIP = "<node-ip>"
+	}
+
 	if mainIP == "" {
 		return fmt.Errorf("could not determine main IP address")
 	}
 
 	// Create secure temporary directory
-	tmpDir, err := os.MkdirTemp("", "kubeadm-*")
+	tmpDir, err := fsys.MkdirTemp("kubeadm-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp directory: %v", err)
 	}
-	defer os.RemoveAll(tmpDir)
+	defer fsys.RemoveAll(tmpDir)
 
 	// Set secure permissions
-	if err := os.Chmod(tmpDir, 0700); err != nil {
+	if err := fsys.Chmod(tmpDir, 0700); err != nil {
 		return fmt.Errorf("failed to set permissions on temp directory: %v", err)
 	}
 
+	// Without a shared endpoint the cluster is tied to this node's address
+	endpoint := mainIP + ":6443"
+	if cfg.HighlyAvailable() {
+		endpoint = cfg.APIServerEndpoint()
+	}
+
 	configContent := fmt.Sprintf(`apiVersion: kubeadm.k8s.io/v1beta3
 kind: ClusterConfiguration
 kubernetesVersion: v%s
 networking:
-  podSubnet: 192.168.0.0/16
-controlPlaneEndpoint: "%s:6443"`, config.KubeVersion, mainIP)
+  podSubnet: %s
+  serviceSubnet: %s
+  dnsDomain: %s
+controlPlaneEndpoint: "%s"`, cfg.KubeVersion, cfg.PodSubnet, cfg.ServiceSubnet, cfg.ClusterDomain, endpoint)
+	if cfg.ImageRepository != "" {
+		configContent += fmt.Sprintf("\nimageRepository: %s", cfg.ImageRepository)
+	}
+	// kubeadm sets the kubelet up with the systemd driver, and the two
+	// cgroup drivers must agree. Joining nodes get this from the cluster.
+	if !systemdCgroup(cfg, fsys) {
+		configContent += `
+---
+apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+cgroupDriver: cgroupfs`
+	}
+
+	initCmd := "kubeadm init --config %s"
+	if cfg.HighlyAvailable() {
+		// Upload the certificates so more control plane nodes can join
+		key, err := certificateKey(cfg, r)
+		if err != nil {
+			return err
+		}
+		configContent += fmt.Sprintf(`
+---
+apiVersion: kubeadm.k8s.io/v1beta3
+kind: InitConfiguration
+certificateKey: "%s"`, key)
+		initCmd += " --upload-certs"
+		cfg.CertificateKey = key
+	}
 
 	configPath := filepath.Join(tmpDir, "kubeadm-config.yaml")
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	if err := fsys.WriteFile(configPath, []byte(configContent), 0600); err != nil {
 		return fmt.Errorf("failed to write kubeadm config: %v", err)
 	}
 
-	_, err = exec.Command(fmt.Sprintf("kubeadm init --config %s", configPath), cfg)
+	_, err = r.Run(fmt.Sprintf(initCmd, fsys.Path(configPath)))
 	return err
 }
 
-func configureKubeconfig(cfg *config.Config) error {
-	cmds := []string{
-		"mkdir -p /root/.kube",
-		"cp -i /etc/kubernetes/admin.conf /root/.kube/config",
-		"mkdir -p /home/ubuntu/.kube",
-		"cp -i /etc/kubernetes/admin.conf /home/ubuntu/.kube/config",
-		"chown ubuntu:ubuntu /home/ubuntu/.kube/config",
-	}
-	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
-			// Ignore errors for ubuntu user operations
-			continue
-		}
+// certificateKey generates the key kubeadm encrypts the uploaded control
+// plane certificates with.
+func certificateKey(cfg *config.Config, r exec.Runner) (string, error) {
+	res, err := r.Run("kubeadm certs certificate-key")
+	if err != nil {
+		return "", err
 	}
-	return nil
+	key := strings.TrimSpace(res.Stdout)
+	if key == "" && cfg.DryRun {
+		return "<certificate-key>", nil
+	}
+	if err := config.ValidateCertificateKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
 }
 
-func installCalicoCNI(cfg *config.Config, manifestFiles fs.FS) error {
-	// Create temporary directory for manifest files
-	tmpDir, err := os.MkdirTemp("", "calico-manifests-*")
-	if err != nil {
-		return fmt.Errorf("failed to create temp dir: %v", err)
+func configureKubeconfig(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	// In a dry run kubeadm never wrote admin.conf, so there is nothing to copy
+	adminConf, err := fsys.ReadFile(adminKubeconfig)
+	if err != nil && !cfg.DryRun {
+		return err
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// Extract and apply tigera-operator
-	operatorContent, err := fs.ReadFile(manifestFiles, "manifests/calico/tigera-operator.yaml")
-	if err != nil {
-		return fmt.Errorf("failed to read tigera-operator manifest: %v", err)
+	if err := fsys.MkdirAll("/root/.kube", 0755); err != nil {
+		return err
+	}
+	if err := fsys.WriteFile("/root/.kube/config", adminConf, 0600); err != nil {
+		return err
 	}
 
-	operatorFile := filepath.Join(tmpDir, "tigera-operator.yaml")
-	if err := os.WriteFile(operatorFile, operatorContent, 0644); err != nil {
-		return fmt.Errorf("failed to write tigera-operator manifest: %v", err)
+	name := kubeconfigUser(cfg)
+	if name == "" {
+		return nil
 	}
+	user, err := lookupUser(fsys, name)
+	if err != nil {
+		return err
+	}
+	kubeDir := filepath.Join(user.home, ".kube")
+	if err := fsys.MkdirAll(kubeDir, 0755); err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(filepath.Join(kubeDir, "config"), adminConf, 0600); err != nil {
+		return err
+	}
+	_, err = r.Run(fmt.Sprintf("chown -R %s:%s %s", user.uid, user.gid, exec.Quote(fsys.Path(kubeDir))))
+	return err
+}
 
-	// Apply the operator manifest
-	if _, err := exec.Command(fmt.Sprintf("kubectl create -f %s", operatorFile), cfg); err != nil {
-		return fmt.Errorf("failed to apply tigera-operator: %v", err)
+// kubeconfigUser returns who gets a copy of the admin kubeconfig besides
+// root, or "" for nobody.
+func kubeconfigUser(cfg *config.Config) string {
+	name := cfg.KubeconfigUser
+	if name == "" {
+		name = os.Getenv("SUDO_USER")
 	}
+	if name == "root" {
+		return ""
+	}
+	return name
+}
 
-	// Add a delay to allow CRDs to be established
-	fmt.Println("Waiting for Calico CRDs to be established...")
-	time.Sleep(20 * time.Second)
+// passwdUser is an account from /etc/passwd.
+type passwdUser struct {
+	uid, gid, home string
+}
 
-	// Wait for specific CRDs to be established
-	crds := []string{
-		"installations.operator.tigera.io",
-		"tigerastatuses.operator.tigera.io",
-		"ippools.crd.projectcalico.org",
+// lookupUser finds name in the node's /etc/passwd.
+func lookupUser(fsys hostfs.FS, name string) (passwdUser, error) {
+	content, err := fsys.ReadFile("/etc/passwd")
+	if err != nil {
+		return passwdUser{}, fmt.Errorf("failed to read /etc/passwd: %v", err)
 	}
-
-	for _, crd := range crds {
-		fmt.Printf("Waiting for CRD %s...\n", crd)
-		if _, err := exec.Command(fmt.Sprintf("kubectl wait --for=condition=established --timeout=60s crd/%s", crd), cfg); err != nil {
-			return fmt.Errorf("timeout waiting for CRD %s: %v", crd, err)
+	for _, line := range strings.Split(string(content), "\n") {
+		// Lines look like "ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash"
+		fields := strings.Split(line, ":")
+		if len(fields) != 7 || fields[0] != name {
+			continue
+		}
+		user := passwdUser{uid: fields[2], gid: fields[3], home: fields[5]}
+		_, uidErr := strconv.ParseUint(user.uid, 10, 32)
+		_, gidErr := strconv.ParseUint(user.gid, 10, 32)
+		if uidErr != nil || gidErr != nil || !filepath.IsAbs(user.home) || filepath.Clean(user.home) == "/" {
+			return passwdUser{}, fmt.Errorf("invalid /etc/passwd entry for %s", name)
 		}
+		return user, nil
 	}
+	return passwdUser{}, fmt.Errorf("user %s not found in /etc/passwd", name)
+}
 
-	// Extract and apply custom-resources
-	customResContent, err := fs.ReadFile(manifestFiles, "manifests/calico/custom-resources.yaml")
+func installCalicoCNI(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
 	if err != nil {
-		return fmt.Errorf("failed to read custom-resources manifest: %v", err)
+		return err
 	}
+	ctx := context.Background()
 
-	customResFile := filepath.Join(tmpDir, "custom-resources.yaml")
-	if err := os.WriteFile(customResFile, customResContent, 0644); err != nil {
-		return fmt.Errorf("failed to write custom-resources manifest: %v", err)
+	operator, err := RenderManifest(cfg, manifestFiles, calicoOperatorManifest)
+	if err != nil {
+		return fmt.Errorf("failed to read tigera-operator manifest: %v", err)
 	}
-
-	// Apply the custom resources manifest
-	if _, err := exec.Command(fmt.Sprintf("kubectl create -f %s", customResFile), cfg); err != nil {
-		return fmt.Errorf("failed to apply custom-resources: %v", err)
+	if err := client.Apply(ctx, operator); err != nil {
+		return err
 	}
 
-	// Wait for tigera-operator pod to be running
-	fmt.Println("Waiting for tigera-operator pod to be ready...")
-	if _, err := exec.Command(fmt.Sprintf("kubectl wait --for=condition=Ready pod -l k8s-app=tigera-operator -n tigera-operator --timeout=%s", config.KubectlTimeout), cfg); err != nil {
-		return fmt.Errorf("timeout waiting for tigera-operator: %v", err)
+	// The custom resources can't be created until their CRDs are served
+	err = waitFor(cfg, "Calico CRDs", wait.All(
+		wait.CRDEstablished(client, "installations.operator.tigera.io"),
+		wait.CRDEstablished(client, "tigerastatuses.operator.tigera.io"),
+		wait.CRDEstablished(client, "ippools.crd.projectcalico.org"),
+	))
+	if err != nil {
+		return err
 	}
 
-	// Wait for Calico installation to be ready
-	fmt.Println("Waiting for Calico installation to be ready...")
-	if _, err := exec.Command("kubectl wait --for=condition=Ready installation.operator.tigera.io/default --timeout=300s", cfg); err != nil {
-		return fmt.Errorf("timeout waiting for Calico installation: %v", err)
+	customResources, err := RenderManifest(cfg, manifestFiles, calicoResourcesManifest)
+	if err != nil {
+		return fmt.Errorf("failed to read custom-resources manifest: %v", err)
+	}
+	if err := client.Apply(ctx, customResources); err != nil {
+		return err
 	}
 
-	// Wait for calico-node pods
-	fmt.Println("Waiting for calico-node pods to be ready...")
-	if _, err := exec.Command("kubectl wait --for=condition=Ready pod -l k8s-app=calico-node -n calico-system --timeout=300s", cfg); err != nil {
-		// If the first attempt fails, check if the namespace exists
-		if _, err := exec.Command("kubectl get ns calico-system", cfg); err != nil {
-			return fmt.Errorf("calico-system namespace not found: %v", err)
-		}
-
-		// Show pod status for debugging
-		if _, err := exec.Command("kubectl get pods -n calico-system", cfg); err != nil {
-			return fmt.Errorf("failed to get calico pods status: %v", err)
-		}
-
-		// Try waiting one more time with a longer timeout
-		time.Sleep(30 * time.Second)
-		if _, err := exec.Command("kubectl wait --for=condition=Ready pod -l k8s-app=calico-node -n calico-system --timeout=300s", cfg); err != nil {
-			return fmt.Errorf("timeout waiting for calico-node pods: %v", err)
-		}
+	if err := waitFor(cfg, "tigera-operator", wait.DeploymentAvailable(client, "tigera-operator", "tigera-operator")); err != nil {
+		return err
 	}
 
-	return nil
+	// The operator creates calico-system and its DaemonSet as it goes
+	return waitFor(cfg, "Calico installation", wait.All(
+		wait.ConditionTrue(client, calicoInstallations, "", "default", "Ready"),
+		wait.DaemonSetRolledOut(client, "calico-system", "calico-node"),
+	))
 }
 
-func waitForNodes(cfg *config.Config) error {
-	_, err := exec.Command(fmt.Sprintf("kubectl wait --for=condition=Ready --all nodes --timeout=%s", config.KubectlTimeout), cfg)
-	return err
+func waitForNodes(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	return waitFor(cfg, "nodes to be Ready", wait.NodesReady(client))
 }
 
-func testKubernetesVersion(cfg *config.Config) error {
-	out, err := exec.Command("kubectl version -o json", cfg)
+func testKubernetesVersion(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
 	if err != nil {
 		return err
 	}
+	if cfg.DryRun {
+		return nil
+	}
 
-	if !strings.Contains(out, fmt.Sprintf("v%s", config.KubeVersion)) {
-		return fmt.Errorf("kubernetes version mismatch")
+	version, err := client.ServerVersion()
+	if err != nil {
+		return err
+	}
+	if version != "v"+cfg.KubeVersion {
+		return fmt.Errorf("kubernetes version mismatch, expected v%s but the API server is %s", cfg.KubeVersion, version)
 	}
 	return nil
 }
 
-func configureAsSingleNode(cfg *config.Config) error {
-	if _, err := exec.Command("kubectl taint nodes --all node-role.kubernetes.io/control-plane:NoSchedule-", cfg); err != nil {
+func configureAsSingleNode(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
 		return err
 	}
-	time.Sleep(10 * time.Second) // Wait for taint to take effect
-	return nil
+	if err := client.RemoveTaint(context.Background(), controlPlaneTaint, corev1.TaintEffectNoSchedule); err != nil {
+		return err
+	}
+	return waitFor(cfg, "the control plane taint to be removed", wait.NoTaint(client, controlPlaneTaint, corev1.TaintEffectNoSchedule))
 }
 
-func testNginxPod(cfg *config.Config) error {
-	cmds := []string{
-		"kubectl run --image nginx --namespace default nginx",
-		fmt.Sprintf("kubectl wait --for=condition=Ready --all pods --namespace default --timeout=%s", config.KubectlTimeout),
-		"kubectl delete pod nginx --namespace default",
+func testNginxPod(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
 	}
-	for _, cmd := range cmds {
-		if _, err := exec.Command(cmd, cfg); err != nil {
-			return err
-		}
+	ctx := context.Background()
+
+	// Clear out a pod left by an earlier, failed run
+	if err := client.DeletePod(ctx, "default", "nginx"); err != nil {
+		return err
+	}
+	if err := waitFor(cfg, "an old nginx test pod to go", podGone(client, "default", "nginx")); err != nil {
+		return err
 	}
-	return nil
-}
 
-func waitForPodsRunning(cfg *config.Config) error {
-	timeout := time.After(5 * time.Minute)
-	tick := time.Tick(10 * time.Second)
-
-	for {
-		select {
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for pods to be running")
-		case <-tick:
-			out, err := exec.Command("kubectl get pods --all-namespaces --no-headers", cfg)
-			if err != nil {
-				return err
-			}
-
-			nonRunningCount := 0
-			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
-				if line != "" && !strings.Contains(line, "Running") {
-					nonRunningCount++
-				}
-			}
-
-			if nonRunningCount == 0 {
-				return nil
-			}
-		}
+	if err := client.RunPod(ctx, "default", "nginx", nginxImage); err != nil {
+		return err
+	}
+	if err := waitFor(cfg, "the nginx test pod", wait.PodsReady(client, "default", "run=nginx")); err != nil {
+		return err
 	}
+	return client.DeletePod(ctx, "default", "nginx")
 }
 
-func checkWorkerServices(cfg *config.Config) error {
-	_, err := exec.Command("systemctl is-active containerd", cfg)
-	return err
-}
+// The PVC and pod used to check the local-path StorageClass.
+const localPathTestName = "local-path-test"
 
-func installMetricsServer(cfg *config.Config, manifestFiles fs.FS) error {
-	fmt.Println("Installing metrics server...")
-	metricsContent, err := fs.ReadFile(manifestFiles, "manifests/metrics-server.yaml")
+// testLocalPathStorage checks that a claim on the default StorageClass gets
+// a volume and that a pod can write to it.
+func testLocalPathStorage(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
 	if err != nil {
-		return fmt.Errorf("failed to read metrics-server manifest: %v", err)
+		return err
 	}
+	ctx := context.Background()
 
-	tmpDir, err := os.MkdirTemp("", "metrics-server-*")
+	// Clear out what an earlier, failed run left
+	if err := client.DeletePod(ctx, "default", localPathTestName); err != nil {
+		return err
+	}
+	if err := client.DeletePVC(ctx, "default", localPathTestName); err != nil {
+		return err
+	}
+	err = waitFor(cfg, "an old storage test to go", wait.All(
+		podGone(client, "default", localPathTestName),
+		pvcGone(client, "default", localPathTestName),
+	))
 	if err != nil {
-		return fmt.Errorf("failed to create temp dir: %v", err)
+		return err
 	}
-	defer os.RemoveAll(tmpDir)
 
-	metricsFile := filepath.Join(tmpDir, "metrics-server.yaml")
-	if err := os.WriteFile(metricsFile, metricsContent, 0644); err != nil {
-		return fmt.Errorf("failed to write metrics-server manifest: %v", err)
+	// No storage class is named, so this also checks local-path is the default
+	err = client.CreatePVC(ctx, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: localPathTestName, Namespace: "default"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("16Mi")},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = client.CreatePod(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: localPathTestName, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:         localPathTestName,
+				Image:        busyboxImage,
+				Command:      []string{"sh", "-c", `echo ok > /data/test && [ "$(cat /data/test)" = ok ]`},
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: localPathTestName},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return err
 	}
 
-	if _, err := exec.Command(fmt.Sprintf("kubectl apply -f %s", metricsFile), cfg); err != nil {
-		return fmt.Errorf("failed to apply metrics-server: %v", err)
+	// The claim only binds once the pod is scheduled
+	err = waitFor(cfg, "the storage test pod", wait.All(
+		wait.PVCBound(client, "default", localPathTestName),
+		wait.PodSucceeded(client, "default", localPathTestName),
+	))
+	if err != nil {
+		return err
+	}
+	if err := client.DeletePod(ctx, "default", localPathTestName); err != nil {
+		return err
 	}
+	return client.DeletePVC(ctx, "default", localPathTestName)
+}
 
-	return nil
+// pvcGone waits for a PersistentVolumeClaim to be deleted.
+func pvcGone(client *kube.Client, namespace, name string) wait.Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		_, err := client.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		return gone(fmt.Sprintf("pvc %s/%s", namespace, name), err)
+	}
+}
+
+// podGone waits for a pod to be deleted.
+func podGone(client *kube.Client, namespace, name string) wait.Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		_, err := client.Clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		return gone(fmt.Sprintf("pod %s/%s", namespace, name), err)
+	}
+}
+
+// gone judges the Get of an object being deleted. Only errors that may
+// clear up keep the wait going.
+func gone(id string, err error) (bool, string, error) {
+	switch {
+	case err == nil:
+		return false, id + " (still exists)", nil
+	case apierrors.IsNotFound(err):
+		return true, "", nil
+	case wait.Transient(err):
+		return false, fmt.Sprintf("%s (%v)", id, err), nil
+	}
+	return false, "", fmt.Errorf("failed to get %s: %v", id, err)
+}
+
+func waitForPodsRunning(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	return waitFor(cfg, "all pods to be ready", wait.PodsReady(client, "", ""))
+}
+
+func checkWorkerServices(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	_, err := r.Run("systemctl is-active containerd")
+	return err
 }
@@ -0,0 +1,350 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/kube"
+	"go-install-kubernetes/pkg/wait"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// manifestFiles are the embedded manifests, read from the repository.
+var manifestFiles = os.DirFS(filepath.Join("..", ".."))
+
+// recordingFS keeps a copy of every file written, including ones a step
+// removes again before returning.
+type recordingFS struct {
+	hostfs.FS
+	written map[string][]byte
+}
+
+func newRecordingFS(t *testing.T) *recordingFS {
+	return &recordingFS{FS: hostfs.New(t.TempDir()), written: map[string][]byte{}}
+}
+
+func (f *recordingFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.written[name] = data
+	return f.FS.WriteFile(name, data, perm)
+}
+
+// writtenAs returns the one file written under a name ending in suffix.
+func (f *recordingFS) writtenAs(t *testing.T, suffix string) []byte {
+	t.Helper()
+	for name, data := range f.written {
+		if strings.HasSuffix(name, suffix) {
+			return data
+		}
+	}
+	t.Fatalf("nothing written to *%s", suffix)
+	return nil
+}
+
+// fakeCluster is a kube.Client on fake clientsets. Server-side applies are
+// recorded rather than stored, since the fake tracker can only apply to
+// objects that already exist.
+type fakeCluster struct {
+	*kube.Client
+	applied []string
+}
+
+// The kinds the test manifests use, for the fake REST mapper.
+var fakeKinds = []struct {
+	gvk   schema.GroupVersionKind
+	scope meta.RESTScope
+}{
+	{schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, meta.RESTScopeNamespace},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"}, meta.RESTScopeRoot},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, meta.RESTScopeNamespace},
+}
+
+// useFakeCluster points the steps at a fake cluster holding objects, with
+// waits that give up quickly.
+func useFakeCluster(t *testing.T, objects ...runtime.Object) *fakeCluster {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, kind := range fakeKinds {
+		mapper.Add(kind.gvk, kind.scope)
+	}
+
+	cluster := &fakeCluster{}
+	dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		cluster.applied = append(cluster.applied, obj.GetKind()+" "+obj.GetName())
+		return true, obj, nil
+	})
+	cluster.Client = kube.New(fake.NewSimpleClientset(objects...), dyn, mapper)
+
+	oldClient, oldBackoff := newKubeClient, waitBackoff
+	newKubeClient = func(*config.Config, hostfs.FS, string) (*kube.Client, error) {
+		return cluster.Client, nil
+	}
+	waitBackoff = wait.Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Factor: 2}
+	t.Cleanup(func() {
+		newKubeClient, waitBackoff = oldClient, oldBackoff
+	})
+	return cluster
+}
+
+func TestKubeadmInit(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(*config.Config, *exec.Recorder)
+		want        []string
+		uploadCerts bool
+	}{
+		{
+			name: "single",
+			want: []string{"ip route get 1", "kubeadm init --config "},
+		},
+		{
+			name: "kube-vip",
+			setup: func(cfg *config.Config, r *exec.Recorder) {
+				cfg.KubeVIP = "10.0.0.100"
+				r.On("kubeadm certs certificate-key", exec.Result{Stdout: strings.Repeat("ab", 32) + "\n"})
+			},
+			want:        []string{"ip route get 1", "kubeadm certs certificate-key", "kubeadm init --config "},
+			uploadCerts: true,
+		},
+		{
+			name: "cgroupfs",
+			setup: func(cfg *config.Config, r *exec.Recorder) {
+				cgroupfs := false
+				cfg.Containerd.SystemdCgroup = &cgroupfs
+				cfg.ImageRepository = "registry.internal/k8s"
+			},
+			want: []string{"ip route get 1", "kubeadm init --config "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			r := exec.NewRecorder().On("ip route get 1", exec.Result{Stdout: "1.0.0.0 via 10.0.0.1 dev eth0 src 10.0.0.5 uid 0\n"})
+			if tt.setup != nil {
+				tt.setup(cfg, r)
+			}
+			fsys := newRecordingFS(t)
+
+			if err := kubeadmInit(cfg, r, fsys); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Expect(tt.want...); err != nil {
+				t.Error(err)
+			}
+			cmds := r.Commands()
+			if init := cmds[len(cmds)-1]; strings.HasSuffix(init, " --upload-certs") != tt.uploadCerts {
+				t.Errorf("init command %q, want --upload-certs %v", init, tt.uploadCerts)
+			}
+			checkGolden(t, filepath.Join("kubeadm", tt.name+".yaml"), fsys.writtenAs(t, "/kubeadm-config.yaml"))
+		})
+	}
+}
+
+func TestInstallKubeVIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*config.Config)
+	}{
+		{"ipv4", func(cfg *config.Config) {
+			cfg.KubeVIP = "10.0.0.100"
+		}},
+		// The port comes from the endpoint, and an IPv6 VIP is a /128
+		{"ipv6-port", func(cfg *config.Config) {
+			cfg.KubeVIP = "fd00::100"
+			cfg.ControlPlaneEndpoint = "[fd00::100]:8443"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.KubeVIPInterface = "eth0"
+			tt.setup(cfg)
+			fsys := newRecordingFS(t)
+
+			if err := installKubeVIP(cfg, exec.NewRecorder(), fsys, manifestFiles); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("kube-vip", tt.name+".yaml"), fsys.writtenAs(t, kubeVIPManifest))
+		})
+	}
+}
+
+func TestKubeadmInitWithoutAddress(t *testing.T) {
+	r := exec.NewRecorder().On("ip route get 1", exec.Result{Stdout: "unreachable\n"})
+	err := kubeadmInit(config.New(), r, newRecordingFS(t))
+	if err == nil || !strings.Contains(err.Error(), "main IP address") {
+		t.Fatalf("expected an error about the IP address, got %v", err)
+	}
+	if err := r.Expect("ip route get 1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigureKubeconfig(t *testing.T) {
+	passwd := "root:x:0:0:root:/root:/bin/bash\nubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash\nadmin:x:1001:1002::/srv/admin:/bin/sh\n"
+	tests := []struct {
+		name     string
+		setting  string
+		sudoUser string
+		path     string
+		chown    string
+		err      string
+	}{
+		{"setting", "ubuntu", "admin", "/home/ubuntu/.kube/config", "chown -R 1000:1000 ", ""},
+		{"sudo user", "", "admin", "/srv/admin/.kube/config", "chown -R 1001:1002 ", ""},
+		// Run as root without sudo, only root gets a copy
+		{"no user", "", "", "", "", ""},
+		{"root", "", "root", "", "", ""},
+		{"unknown user", "nobody", "", "", "", "user nobody not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SUDO_USER", tt.sudoUser)
+			cfg := config.New()
+			cfg.KubeconfigUser = tt.setting
+			fsys := newRecordingFS(t)
+			if err := fsys.MkdirAll("/etc/kubernetes", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.WriteFile(adminKubeconfig, []byte("admin"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.WriteFile("/etc/passwd", []byte(passwd), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := exec.NewRecorder()
+
+			err := configureKubeconfig(cfg, r, fsys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(fsys.written["/root/.kube/config"]) != "admin" {
+				t.Error("root's kubeconfig not written")
+			}
+			if tt.path == "" {
+				if err := r.Expect(); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if string(fsys.written[tt.path]) != "admin" {
+				t.Errorf("%s not written", tt.path)
+			}
+			if err := r.Expect(tt.chown + exec.Quote(fsys.Path(filepath.Dir(tt.path)))); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestInstallFlannelCNI(t *testing.T) {
+	cluster := useFakeCluster(t, &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-flannel", Name: "kube-flannel-ds", Generation: 1},
+		Status: appsv1.DaemonSetStatus{
+			ObservedGeneration:     1,
+			DesiredNumberScheduled: 1,
+			UpdatedNumberScheduled: 1,
+			NumberAvailable:        1,
+		},
+	})
+	cfg := config.New()
+	cfg.CNI = config.CNIFlannel
+	r := exec.NewRecorder()
+
+	if err := installFlannelCNI(cfg, r, newRecordingFS(t), manifestFiles); err != nil {
+		t.Fatal(err)
+	}
+	// Everything goes through the API, not the shell
+	if err := r.Expect(); err != nil {
+		t.Error(err)
+	}
+	want := []string{
+		"Namespace kube-flannel",
+		"ServiceAccount flannel",
+		"ClusterRole flannel",
+		"ClusterRoleBinding flannel",
+		"ConfigMap kube-flannel-cfg",
+		"DaemonSet kube-flannel-ds",
+	}
+	if !slices.Equal(cluster.applied, want) {
+		t.Errorf("applied %v, want %v", cluster.applied, want)
+	}
+}
+
+func TestWaitForPodsRunning(t *testing.T) {
+	ready := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns-abc"},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	crashing := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "nginx",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			}},
+		},
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		useFakeCluster(t, ready)
+		r := exec.NewRecorder()
+		if err := waitForPodsRunning(config.New(), r, newRecordingFS(t)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Expect(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("crashing", func(t *testing.T) {
+		useFakeCluster(t, ready, crashing)
+		cfg := config.New()
+		cfg.KubectlTimeout = "50ms"
+
+		err := waitForPodsRunning(cfg, exec.NewRecorder(), newRecordingFS(t))
+		var timeout *wait.TimeoutError
+		if !errors.As(err, &timeout) {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+		if want := "pod default/nginx (CrashLoopBackOff)"; timeout.Blocking != want {
+			t.Errorf("blocking on %q, want %q", timeout.Blocking, want)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package install
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// The embedded kube-vip static pod and where it is written on the node.
+const (
+	kubeVIPTemplate = "manifests/kube-vip.yaml.tmpl"
+	kubeVIPManifest = "/etc/kubernetes/manifests/kube-vip.yaml"
+)
+
+// The kubeconfig files kube-vip can talk to the API server with.
+const (
+	adminConf      = "/etc/kubernetes/admin.conf"
+	superAdminConf = "/etc/kubernetes/super-admin.conf"
+)
+
+// installKubeVIP writes the kube-vip static pod. On the first control plane
+// node it goes in before kubeadm init, so the kubelet starts it before the
+// API server it fronts exists. Other control plane nodes add it after
+// joining through the VIP the first one announces.
+func installKubeVIP(cfg *config.Config, r exec.Runner, fsys hostfs.FS, manifestFiles fs.FS) error {
+	if cfg.KubeVIPInterface == "" {
+		iface, err := defaultInterface(cfg, r)
+		if err != nil {
+			return err
+		}
+		cfg.KubeVIPInterface = iface
+	}
+
+	content, err := RenderManifest(cfg, manifestFiles, kubeVIPTemplate)
+	if err != nil {
+		return err
+	}
+
+	// From 1.29 admin.conf only gets its rights once kubeadm init has set up
+	// RBAC, which needs the API server reachable through the VIP first
+	if !cfg.JoinControlPlane {
+		version, err := parseKubeVersion(cfg.KubeVersion)
+		if err != nil {
+			return err
+		}
+		if version.major > 1 || version.minor >= 29 {
+			content = []byte(strings.ReplaceAll(string(content), "path: "+adminConf, "path: "+superAdminConf))
+		}
+	}
+
+	if err := fsys.MkdirAll("/etc/kubernetes/manifests", 0755); err != nil {
+		return err
+	}
+	return fsys.WriteFile(kubeVIPManifest, content, 0600)
+}
+
+// switchKubeVIPToAdminConf points kube-vip back at admin.conf once kubeadm
+// init has given it its rights, so that it doesn't keep using super-admin.conf,
+// which bypasses RBAC. The kubelet restarts the pod when its manifest changes.
+func switchKubeVIPToAdminConf(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	content, err := fsys.ReadFile(kubeVIPManifest)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(content), "path: "+superAdminConf) {
+		return nil
+	}
+	content = []byte(strings.ReplaceAll(string(content), "path: "+superAdminConf, "path: "+adminConf))
+	return fsys.WriteFile(kubeVIPManifest, content, 0600)
+}
+
+// defaultInterface returns the interface of the default route, which is
+// where the VIP is announced unless configured otherwise.
+func defaultInterface(cfg *config.Config, r exec.Runner) (string, error) {
+	res, err := r.Run("ip route get 1")
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(res.Stdout)
+	for i, field := range fields {
+		if field == "dev" && i+1 < len(fields) {
+			return fields[i+1], nil
+		}
+	}
+	if cfg.DryRun {
+		return "<interface>", nil
+	}
+	return "", fmt.Errorf("could not determine the default network interface, set kubeVIPInterface")
+}
@@ -0,0 +1,34 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+	"text/template"
+
+	"go-install-kubernetes/pkg/config"
+)
+
+// RenderManifest returns the embedded manifest at path. Manifests ending in
+// .tmpl are Go templates and are rendered against cfg first.
+func RenderManifest(cfg *config.Config, manifestFiles fs.FS, path string) ([]byte, error) {
+	content, err := fs.ReadFile(manifestFiles, path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".tmpl") {
+		return content, nil
+	}
+
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %v", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, cfg); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %v", path, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package install
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// registryHostsDir is where containerd looks for a hosts.toml per registry,
+// in a directory named after the registry host.
+const registryHostsDir = "/etc/containerd/certs.d"
+
+// configureRegistries writes a hosts.toml for each configured registry. The
+// directory belongs to the installer, so entries for registries no longer in
+// the settings are removed.
+func configureRegistries(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if err := fsys.RemoveAll(registryHostsDir); err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(registryHostsDir, 0755); err != nil {
+		return err
+	}
+
+	for _, reg := range cfg.Registries {
+		dir := filepath.Join(registryHostsDir, reg.Host)
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		// The CA is copied next to hosts.toml so that it is removed with it
+		var caPath string
+		if reg.CAFile != "" {
+			ca, err := fsys.ReadFile(reg.CAFile)
+			if err != nil && !cfg.DryRun {
+				return fmt.Errorf("failed to read CA file for registry %s: %v", reg.Host, err)
+			}
+			caPath = filepath.Join(dir, "ca.crt")
+			if err := fsys.WriteFile(caPath, ca, 0644); err != nil {
+				return err
+			}
+		}
+
+		content := registryHosts(reg, caPath)
+		if err := fsys.WriteFile(filepath.Join(dir, "hosts.toml"), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registryHosts renders the hosts.toml for reg. Mirrors are only used to
+// pull, while the server also takes pushes.
+func registryHosts(reg config.Registry, caPath string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "server = %s\n", tomlString(reg.ServerURL()))
+	writeRegistryTLS(&b, "", reg, caPath)
+
+	for _, mirror := range reg.Mirrors {
+		fmt.Fprintf(&b, "\n[host.%s]\n", tomlString(strings.TrimSuffix(mirror, "/")))
+		fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+		writeRegistryTLS(&b, "  ", reg, caPath)
+	}
+	return b.String()
+}
+
+func writeRegistryTLS(b *strings.Builder, indent string, reg config.Registry, caPath string) {
+	if caPath != "" {
+		fmt.Fprintf(b, "%sca = %s\n", indent, tomlString(caPath))
+	}
+	if reg.Insecure {
+		fmt.Fprintf(b, "%sskip_verify = true\n", indent)
+	}
+}
+
+// newCRIRegistry is the registry section of containerd's config.toml. The
+// logins can't go in hosts.toml, so they are set per server host here, which
+// is the host containerd looks them up by.
+func newCRIRegistry(cfg *config.Config) criRegistry {
+	reg := criRegistry{ConfigPath: registryHostsDir}
+	for _, r := range cfg.Registries {
+		if r.Username == "" {
+			continue
+		}
+		if reg.Configs == nil {
+			reg.Configs = map[string]registryConfig{}
+		}
+		u, _ := url.Parse(r.ServerURL())
+		reg.Configs[u.Host] = registryConfig{Auth: registryAuth{Username: r.Username, Password: r.Password}}
+	}
+	return reg
+}
+
+// hasRegistryLogins reports whether config.toml will hold passwords.
+func hasRegistryLogins(cfg *config.Config) bool {
+	for _, reg := range cfg.Registries {
+		if reg.Username != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tomlString quotes s as a TOML basic string. Go's quoting matches for the
+// printable characters the settings are validated to.
+func tomlString(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,276 @@
+package install
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// Where a release install puts containerd, runc and the CNI plugins, as the
+// upstream getting started guide does.
+const (
+	containerdBinDir   = "/usr/local/bin"
+	containerdUnitFile = "/etc/systemd/system/containerd.service"
+	runcBinary         = "/usr/local/sbin/runc"
+	cniBinDir          = "/opt/cni/bin"
+)
+
+// containerdBinaries are the files in the bin directory of a containerd
+// release tarball.
+var containerdBinaries = []string{
+	"containerd",
+	"containerd-shim",
+	"containerd-shim-runc-v1",
+	"containerd-shim-runc-v2",
+	"containerd-stress",
+	"ctr",
+}
+
+// containerdUnit is the unit file from the containerd repository, which the
+// release tarball doesn't include.
+const containerdUnit = `[Unit]
+Description=containerd container runtime
+Documentation=https://containerd.io
+After=network.target local-fs.target dbus.service
+
+[Service]
+ExecStartPre=-/sbin/modprobe overlay
+ExecStart=/usr/local/bin/containerd
+
+Type=notify
+Delegate=yes
+KillMode=process
+Restart=always
+RestartSec=5
+
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+OOMScoreAdjust=-999
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// releaseAsset is a file downloaded from a release, along with the
+// checksum file published beside it.
+type releaseAsset struct {
+	name        string
+	url         string
+	checksumURL string
+}
+
+func containerdAsset(version string) releaseAsset {
+	name := fmt.Sprintf("containerd-%s-linux-%s.tar.gz", version, runtime.GOARCH)
+	url := fmt.Sprintf("https://github.com/containerd/containerd/releases/download/v%s/%s", version, name)
+	return releaseAsset{name: name, url: url, checksumURL: url + ".sha256sum"}
+}
+
+// runcAsset is the static runc binary. runc publishes one checksum file
+// covering every architecture.
+func runcAsset(version string) releaseAsset {
+	base := fmt.Sprintf("https://github.com/opencontainers/runc/releases/download/v%s/", version)
+	name := "runc." + runtime.GOARCH
+	return releaseAsset{name: name, url: base + name, checksumURL: base + "runc.sha256sum"}
+}
+
+func cniPluginsAsset(version string) releaseAsset {
+	name := fmt.Sprintf("cni-plugins-linux-%s-v%s.tgz", runtime.GOARCH, version)
+	url := fmt.Sprintf("https://github.com/containernetworking/plugins/releases/download/v%s/%s", version, name)
+	return releaseAsset{name: name, url: url, checksumURL: url + ".sha256"}
+}
+
+// kubeadmAsset is the kubeadm binary of a Kubernetes release. dl.k8s.io
+// publishes a file holding only its hash beside it.
+func kubeadmAsset(version string) releaseAsset {
+	url := fmt.Sprintf("https://dl.k8s.io/release/v%s/bin/linux/%s/kubeadm", version, runtime.GOARCH)
+	return releaseAsset{name: "kubeadm", url: url, checksumURL: url + ".sha256"}
+}
+
+// installContainerdRelease installs containerd and runc from their release
+// tarballs, for containerdSource release.
+func installContainerdRelease(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	tmpDir, err := fsys.MkdirTemp("containerd-release-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer fsys.RemoveAll(tmpDir)
+
+	archive, err := downloadRelease(cfg, r, fsys, tmpDir, containerdAsset(cfg.ContainerdVersion))
+	if err != nil {
+		return err
+	}
+	// The tarball holds a bin directory
+	if _, err := r.Run(fmt.Sprintf("tar -C %s -xzf %s", fsys.Path(filepath.Dir(containerdBinDir)), fsys.Path(archive))); err != nil {
+		return err
+	}
+
+	binary, err := downloadRelease(cfg, r, fsys, tmpDir, runcAsset(cfg.RuncVersion))
+	if err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(filepath.Dir(runcBinary), 0755); err != nil {
+		return err
+	}
+	if _, err := r.Run(fmt.Sprintf("install -m 755 %s %s", fsys.Path(binary), fsys.Path(runcBinary))); err != nil {
+		return err
+	}
+
+	return fsys.WriteFile(containerdUnitFile, []byte(containerdUnit), 0644)
+}
+
+// installCNIPlugins unpacks the CNI plugins release. It runs after the
+// Kubernetes packages, which bring kubernetes-cni with plugins of its own,
+// so that the pinned release is what ends up in the plugin directory.
+func installCNIPlugins(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	tmpDir, err := fsys.MkdirTemp("cni-plugins-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer fsys.RemoveAll(tmpDir)
+
+	archive, err := downloadRelease(cfg, r, fsys, tmpDir, cniPluginsAsset(cfg.CNIPluginsVersion))
+	if err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(cniBinDir, 0755); err != nil {
+		return err
+	}
+	_, err = r.Run(fmt.Sprintf("tar -C %s -xzf %s", fsys.Path(cniBinDir), fsys.Path(archive)))
+	return err
+}
+
+// removeContainerdRelease removes a release install of containerd, runc
+// and the CNI plugins, which the package manager knows nothing about. The
+// unit file is only written by a release install, so it marks one.
+func removeContainerdRelease(r exec.Runner, fsys hostfs.FS) error {
+	if _, err := fsys.ReadFile(containerdUnitFile); err != nil {
+		return nil
+	}
+	r.Run("systemctl disable containerd")
+
+	paths := []string{containerdUnitFile, runcBinary}
+	for _, name := range containerdBinaries {
+		paths = append(paths, filepath.Join(containerdBinDir, name))
+	}
+	for _, path := range paths {
+		if err := removeIfExists(fsys, path); err != nil {
+			return err
+		}
+	}
+	return fsys.RemoveAll(cniBinDir)
+}
+
+// downloadRelease fetches asset into dir and checks it against the
+// published SHA256 checksum, returning the path it was saved to.
+func downloadRelease(cfg *config.Config, r exec.Runner, fsys hostfs.FS, dir string, asset releaseAsset) (string, error) {
+	path := filepath.Join(dir, asset.name)
+	checksumPath := path + ".sha256"
+	if _, err := r.Run(fmt.Sprintf("curl -fsSLo %s %s", fsys.Path(path), asset.url)); err != nil {
+		return "", err
+	}
+	if _, err := r.Run(fmt.Sprintf("curl -fsSLo %s %s", fsys.Path(checksumPath), asset.checksumURL)); err != nil {
+		return "", err
+	}
+
+	content, err := fsys.ReadFile(path)
+	if err != nil {
+		// Nothing was downloaded in a dry run
+		if cfg.DryRun {
+			return path, nil
+		}
+		return "", err
+	}
+	checksums, err := fsys.ReadFile(checksumPath)
+	if err != nil {
+		return "", err
+	}
+
+	want, err := findChecksum(checksums, asset.name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return "", fmt.Errorf("checksum mismatch for %s: got %s, expected %s", asset.name, got, want)
+	}
+	return path, nil
+}
+
+// findChecksum returns the SHA256 for name from a sha256sum style file,
+// with one "<hash>  <name>" line per file. A file with only a hash is for
+// the one asset it was published beside.
+func findChecksum(checksums []byte, name string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(checksums)), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		matches := len(fields) == 2 && filepath.Base(strings.TrimPrefix(fields[1], "*")) == name ||
+			len(fields) == 1 && len(lines) == 1
+		if !matches {
+			continue
+		}
+
+		hash := strings.ToLower(fields[0])
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+			return "", fmt.Errorf("invalid checksum %q for %s", fields[0], name)
+		}
+		return hash, nil
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// checkContainerdVersion compares the running containerd with the
+// containerdVersion setting. Only a release install can promise it, so for
+// a package the difference is reported rather than failing the install.
+func checkContainerdVersion(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	// sudo may leave /usr/local/bin out of the PATH
+	ctr := "ctr"
+	if cfg.ContainerdSource == config.ContainerdSourceRelease {
+		ctr = filepath.Join(containerdBinDir, "ctr")
+	}
+	res, err := r.Run(ctr + " version")
+	if err != nil {
+		return err
+	}
+	version := serverVersion(res.Stdout)
+	if version == "" {
+		// Nothing was really run in a dry run
+		if cfg.DryRun {
+			return nil
+		}
+		return fmt.Errorf("could not find the containerd version in %q", res.Stdout)
+	}
+
+	switch {
+	case version == cfg.ContainerdVersion:
+		fmt.Printf("containerd %s is running\n", version)
+	case cfg.ContainerdSource == config.ContainerdSourceRelease:
+		return fmt.Errorf("containerd %s is running, expected %s", version, cfg.ContainerdVersion)
+	default:
+		fmt.Printf("containerd %s is running from the distribution package rather than %s, set containerdSource to %s to install %s\n",
+			version, cfg.ContainerdVersion, config.ContainerdSourceRelease, cfg.ContainerdVersion)
+	}
+	return nil
+}
+
+// serverVersion returns the daemon's version from ctr version output, which
+// lists the client first and then the server.
+func serverVersion(output string) string {
+	_, server, ok := strings.Cut(output, "Server:")
+	if !ok {
+		return ""
+	}
+	for _, line := range strings.Split(server, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok && key == "Version" {
+			return strings.TrimPrefix(strings.TrimSpace(value), "v")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package install
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+)
+
+func TestFindChecksum(t *testing.T) {
+	hashA := strings.Repeat("a1", 32)
+	hashB := strings.Repeat("b2", 32)
+	sums := hashA + "  containerd-1.7.20-linux-amd64.tar.gz\n" +
+		strings.ToUpper(hashB) + " *bin/runc.amd64\n"
+
+	tests := []struct {
+		checksums string
+		name      string
+		want      string
+		err       string
+	}{
+		{sums, "containerd-1.7.20-linux-amd64.tar.gz", hashA, ""},
+		// Binary mode markers and directories are dropped, the hash lowercased
+		{sums, "runc.amd64", hashB, ""},
+		{sums, "runc.arm64", "", "no checksum for runc.arm64"},
+		// A .sha256sum published beside the asset may hold only the hash
+		{hashA + "\n", "cni-plugins-linux-amd64-v1.5.1.tgz", hashA, ""},
+		{hashA + "\n" + hashB + "\n", "runc.amd64", "", "no checksum for runc.amd64"},
+		{"abc123  runc.amd64\n", "runc.amd64", "", `invalid checksum "abc123"`},
+		{strings.Repeat("zz", 32) + "  runc.amd64\n", "runc.amd64", "", "invalid checksum"},
+		{"", "runc.amd64", "", "no checksum for runc.amd64"},
+	}
+	for _, tt := range tests {
+		got, err := findChecksum([]byte(tt.checksums), tt.name)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("findChecksum(%q, %s): expected %q, got %v", tt.checksums, tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findChecksum(%q, %s): %v", tt.checksums, tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("findChecksum(%q, %s) = %s, want %s", tt.checksums, tt.name, got, tt.want)
+		}
+	}
+}
+
+const ctrVersion = `Client:
+  Version:  v1.7.20
+  Revision: 8fc6bcff51318944179630522a095cc9dbf9f353
+  Go version: go1.22.5
+
+Server:
+  Version:  v1.7.19
+  Revision: 2bf793ef6dc9a18e00cb12efb64355c2c9d5eb41
+  UUID: 6c6a2d5b-0a4e-4c55-8e1b-6b0b0b0b0b0b
+`
+
+func TestServerVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		// The client's version comes first and must be skipped
+		{ctrVersion, "1.7.19"},
+		{"Server:\n  Version:  1.6.33\n", "1.6.33"},
+		{"Client:\n  Version:  v1.7.20\n", ""},
+		{"ctr: failed to dial \"/run/containerd/containerd.sock\": connection refused\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := serverVersion(tt.output); got != tt.want {
+			t.Errorf("serverVersion(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestCheckContainerdVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		version string
+		dryRun  bool
+		output  string
+		cmd     string
+		err     string
+	}{
+		{"release", config.ContainerdSourceRelease, "1.7.19", false, ctrVersion, containerdBinDir + "/ctr version", ""},
+		{"release mismatch", config.ContainerdSourceRelease, "1.7.20", false, ctrVersion, containerdBinDir + "/ctr version", "containerd 1.7.19 is running, expected 1.7.20"},
+		// A package only gets a note that the version differs
+		{"package mismatch", config.ContainerdSourcePackage, "1.7.20", false, ctrVersion, "ctr version", ""},
+		{"no version", config.ContainerdSourcePackage, "1.7.20", false, "", "ctr version", "could not find the containerd version"},
+		{"dry run", config.ContainerdSourceRelease, "1.7.20", true, "", containerdBinDir + "/ctr version", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.ContainerdSource = tt.source
+			cfg.ContainerdVersion = tt.version
+			cfg.DryRun = tt.dryRun
+			r := exec.NewRecorder().On(tt.cmd, exec.Result{Stdout: tt.output})
+
+			err := checkContainerdVersion(cfg, r, newRecordingFS(t))
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected %q, got %v", tt.err, err)
+			}
+			if err := r.Expect(tt.cmd); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDownloadRelease(t *testing.T) {
+	kubeadm := []byte("kubeadm binary")
+	sum := sha256.Sum256(kubeadm)
+	asset := kubeadmAsset("1.31.5")
+
+	tests := []struct {
+		name     string
+		checksum string
+		err      string
+	}{
+		{"match", hex.EncodeToString(sum[:]) + "\n", ""},
+		{"mismatch", strings.Repeat("0", 64) + "\n", "checksum mismatch for kubeadm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// curl is only recorded, so put what it would fetch in place
+			fsys := newRecordingFS(t)
+			if err := fsys.WriteFile("/tmp/kubeadm", kubeadm, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.WriteFile("/tmp/kubeadm.sha256", []byte(tt.checksum), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := exec.NewRecorder()
+
+			path, err := downloadRelease(config.New(), r, fsys, "/tmp", asset)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != "/tmp/kubeadm" {
+				t.Errorf("saved to %s", path)
+			}
+			err = r.Expect(
+				"curl -fsSLo "+fsys.Path("/tmp/kubeadm")+" https://dl.k8s.io/release/v1.31.5/bin/linux/",
+				"curl -fsSLo "+fsys.Path("/tmp/kubeadm.sha256")+" https://dl.k8s.io/release/v1.31.5/bin/linux/",
+			)
+			if err != nil {
+				t.Error(err)
+			}
+			if cmds := r.Commands(); !strings.HasSuffix(cmds[1], "/kubeadm.sha256") {
+				t.Errorf("checksum fetched from %q", cmds[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// Files written by the install steps. Keep this in step with the
+// configure* functions so reset leaves nothing behind. The kubelet settings
+// file depends on the distribution, so it comes from there.
+var installedFiles = []string{
+	"/etc/modules-load.d/containerd.conf",
+	"/etc/sysctl.d/99-kubernetes-cri.conf",
+	"/etc/crictl.yaml",
+	containerdConfigFile,
+	"/root/.kube/config",
+	StateFile,
+}
+
+// State left behind by the CNI plugin and kubeadm reset.
+var cniDirs = []string{
+	"/etc/cni/net.d",
+	"/var/lib/cni",
+	"/var/lib/calico",
+	"/var/run/calico",
+	"/run/flannel",
+	"/var/run/cilium",
+}
+
+// Interfaces created by the CNI plugins, matched by prefix.
+var cniInterfaces = []string{"cali", "tunl0", "vxlan.calico", "cni0", "flannel", "cilium"}
+
+// Reset reverses what the installer did to the node. It is best effort: every
+// step is attempted even if an earlier one fails, since a half-installed
+// node is exactly when a reset is needed.
+func Reset(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	steps := []struct {
+		name string
+		fn   func(*config.Config, exec.Runner, hostfs.FS) error
+	}{
+		{"Reset kubeadm", resetKubeadm},
+		{"Remove CNI state", removeCNIState},
+		{"Remove packages", purgePackages},
+		{"Remove installed files", removeInstalledFiles},
+		{"Reload system settings", reloadSystem},
+	}
+
+	var failed []string
+	for _, step := range steps {
+		fmt.Printf("Executing: %s...\n", step.name)
+		if err := step.fn(cfg, r, fsys); err != nil {
+			fmt.Printf("%s failed: %v\n", step.name, err)
+			failed = append(failed, step.name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("reset incomplete, failed steps: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func resetKubeadm(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if _, err := r.Run("kubeadm reset -f"); err != nil {
+		return err
+	}
+	_, err := r.Run("systemctl stop kubelet")
+	return err
+}
+
+func removeCNIState(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	for _, dir := range cniDirs {
+		if err := fsys.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	res, err := r.Run("ip -o link show")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(res.Stdout), "\n") {
+		// Lines look like "5: cali1234@if3: <BROADCAST,...> ..."
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.SplitN(strings.TrimSuffix(fields[1], ":"), "@", 2)[0]
+		for _, prefix := range cniInterfaces {
+			if strings.HasPrefix(name, prefix) {
+				if _, err := r.Run(fmt.Sprintf("ip link delete %s", name)); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	// kubeadm reset leaves kube-proxy's rules in place
+	return removeIPTablesChains(r)
+}
+
+// cniChainPrefixes start the names of the iptables chains kube-proxy and the
+// CNIs create. Calico's own chains are lower case.
+var cniChainPrefixes = []string{"KUBE-", "cali-", "CILIUM_", "FLANNEL-"}
+
+// removeIPTablesChains deletes the chains kube-proxy and the CNIs created,
+// along with the rules in other chains that jump to them, for both IPv4 and
+// IPv6. Every other rule on the host, such as a firewall's, is left alone.
+func removeIPTablesChains(r exec.Runner) error {
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		if err := removeChains(r, iptables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeChains does the work of removeIPTablesChains with one of iptables
+// or ip6tables, which list and take rules the same way.
+func removeChains(r exec.Runner, iptables string) error {
+	for _, table := range []string{"filter", "nat", "mangle", "raw"} {
+		res, err := r.Run(fmt.Sprintf("%s -t %s -S", iptables, table))
+		if err != nil {
+			return err
+		}
+
+		// Lines look like "-N KUBE-SERVICES" or "-A PREROUTING -m comment
+		// --comment "kubernetes service portals" -j KUBE-SERVICES"
+		var chains, jumps []string
+		for _, line := range strings.Split(strings.TrimSpace(res.Stdout), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			switch {
+			case fields[0] == "-N" && cniChain(fields[1]):
+				chains = append(chains, fields[1])
+			case fields[0] == "-A" && !cniChain(fields[1]) && jumpsToCNIChain(fields):
+				jumps = append(jumps, "-D"+strings.TrimPrefix(line, "-A"))
+			}
+		}
+
+		var cmds []string
+		for _, rule := range jumps {
+			cmds = append(cmds, fmt.Sprintf("%s -t %s %s", iptables, table, rule))
+		}
+		// A chain can only be deleted once nothing jumps to it, so every
+		// chain is flushed first
+		for _, chain := range chains {
+			cmds = append(cmds, fmt.Sprintf("%s -t %s -F %s", iptables, table, chain))
+		}
+		for _, chain := range chains {
+			cmds = append(cmds, fmt.Sprintf("%s -t %s -X %s", iptables, table, chain))
+		}
+		for _, cmd := range cmds {
+			if _, err := r.Run(cmd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cniChain(name string) bool {
+	for _, prefix := range cniChainPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// jumpsToCNIChain reports whether the rule in fields jumps or goes to one of
+// the chains being removed.
+func jumpsToCNIChain(fields []string) bool {
+	for i := 0; i+1 < len(fields); i++ {
+		if (fields[i] == "-j" || fields[i] == "-g") && cniChain(fields[i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func purgePackages(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if cfg.KeepPackages {
+		fmt.Println("Keeping packages")
+		return nil
+	}
+
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	// An air-gapped node can only reach the bundle's repository
+	offline := bundleExtracted(fsys)
+	if offline {
+		d = d.Offline(fsys, filepath.Join(BundleDir, bundlePackages))
+	}
+	if err := d.Unhold(r, kubePackages...); err != nil {
+		return err
+	}
+	if _, err := r.Run("systemctl stop containerd"); err != nil {
+		return err
+	}
+	if err := removeContainerdRelease(r, fsys); err != nil {
+		return err
+	}
+	if err := d.Purge(r, append(kubePackages, d.ContainerdPackage())...); err != nil {
+		return err
+	}
+	if err := d.Autoremove(r); err != nil {
+		return err
+	}
+
+	// The repositories are only removed along with the packages
+	for _, path := range d.RepoFiles() {
+		if err := removeIfExists(fsys, path); err != nil {
+			return err
+		}
+	}
+	if offline {
+		return fsys.RemoveAll(BundleDir)
+	}
+	return d.Refresh(r)
+}
+
+func removeInstalledFiles(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	for _, path := range append(installedFiles, d.KubeletEnvFile()) {
+		if err := removeIfExists(fsys, path); err != nil {
+			return err
+		}
+	}
+	if err := fsys.RemoveAll(registryHostsDir); err != nil {
+		return err
+	}
+
+	// The user's copy of the kubeconfig, from configureKubeconfig
+	name := kubeconfigUser(cfg)
+	if name == "" {
+		return nil
+	}
+	user, err := lookupUser(fsys, name)
+	if err != nil {
+		return err
+	}
+	return removeIfExists(fsys, filepath.Join(user.home, ".kube", "config"))
+}
+
+func reloadSystem(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	cmds := []string{
+		"sysctl --system",
+		"systemctl daemon-reload",
+	}
+	// containerd is still installed but its config has gone
+	if cfg.KeepPackages {
+		cmds = append(cmds, "systemctl restart containerd")
+	}
+	for _, cmd := range cmds {
+		if _, err := r.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeIfExists(fsys hostfs.FS, path string) error {
+	if err := fsys.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
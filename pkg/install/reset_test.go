@@ -0,0 +1,105 @@
+package install
+
+import (
+	"testing"
+
+	"go-install-kubernetes/pkg/exec"
+)
+
+func TestRemoveIPTablesChains(t *testing.T) {
+	r := exec.NewRecorder()
+	r.On("iptables -t filter -S", exec.Result{Stdout: `-P INPUT ACCEPT
+-P FORWARD DROP
+-N KUBE-FIREWALL
+-N cali-INPUT
+-N ufw-user-input
+-A INPUT -j cali-INPUT
+-A INPUT -j KUBE-FIREWALL
+-A INPUT -j ufw-user-input
+-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+-A KUBE-FIREWALL -m comment --comment "block incoming localnet connections" -j DROP
+-A cali-INPUT -j KUBE-FIREWALL
+`})
+	r.On("iptables -t nat -S", exec.Result{Stdout: `-P PREROUTING ACCEPT
+-N KUBE-SERVICES
+-N FLANNEL-POSTRTG
+-A PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES
+-A POSTROUTING -m comment --comment "flanneld masq" -j FLANNEL-POSTRTG
+-A POSTROUTING -o eth0 -j MASQUERADE
+`})
+	r.On("iptables -t raw -S", exec.Result{Stdout: `-P PREROUTING ACCEPT
+-N CILIUM_PRE_raw
+-A PREROUTING -m comment --comment "cilium-feeder: CILIUM_PRE_raw" -g CILIUM_PRE_raw
+`})
+
+	if err := removeIPTablesChains(r); err != nil {
+		t.Fatal(err)
+	}
+	err := r.Expect(
+		"iptables -t filter -S",
+		"iptables -t filter -D INPUT -j cali-INPUT",
+		"iptables -t filter -D INPUT -j KUBE-FIREWALL",
+		"iptables -t filter -F KUBE-FIREWALL",
+		"iptables -t filter -F cali-INPUT",
+		"iptables -t filter -X KUBE-FIREWALL",
+		"iptables -t filter -X cali-INPUT",
+		"iptables -t nat -S",
+		`iptables -t nat -D PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES`,
+		`iptables -t nat -D POSTROUTING -m comment --comment "flanneld masq" -j FLANNEL-POSTRTG`,
+		"iptables -t nat -F KUBE-SERVICES",
+		"iptables -t nat -F FLANNEL-POSTRTG",
+		"iptables -t nat -X KUBE-SERVICES",
+		"iptables -t nat -X FLANNEL-POSTRTG",
+		"iptables -t mangle -S",
+		"iptables -t raw -S",
+		`iptables -t raw -D PREROUTING -m comment --comment "cilium-feeder: CILIUM_PRE_raw" -g CILIUM_PRE_raw`,
+		"iptables -t raw -F CILIUM_PRE_raw",
+		"iptables -t raw -X CILIUM_PRE_raw",
+		// Nothing of the cluster's in IPv6
+		"ip6tables -t filter -S",
+		"ip6tables -t nat -S",
+		"ip6tables -t mangle -S",
+		"ip6tables -t raw -S",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveIP6TablesChains(t *testing.T) {
+	r := exec.NewRecorder()
+	r.On("ip6tables -t filter -S", exec.Result{Stdout: `-P INPUT ACCEPT
+-N KUBE-FORWARD
+-A FORWARD -m comment --comment "kubernetes forwarding rules" -j KUBE-FORWARD
+-A INPUT -s fe80::/10 -p ipv6-icmp -j ACCEPT
+-A KUBE-FORWARD -m conntrack --ctstate INVALID -j DROP
+`})
+	r.On("ip6tables -t nat -S", exec.Result{Stdout: `-P POSTROUTING ACCEPT
+-N cali-nat-outgoing
+-A POSTROUTING -j cali-nat-outgoing
+-A POSTROUTING -o eth0 -j MASQUERADE
+`})
+
+	if err := removeIPTablesChains(r); err != nil {
+		t.Fatal(err)
+	}
+	err := r.Expect(
+		"iptables -t filter -S",
+		"iptables -t nat -S",
+		"iptables -t mangle -S",
+		"iptables -t raw -S",
+		"ip6tables -t filter -S",
+		`ip6tables -t filter -D FORWARD -m comment --comment "kubernetes forwarding rules" -j KUBE-FORWARD`,
+		"ip6tables -t filter -F KUBE-FORWARD",
+		"ip6tables -t filter -X KUBE-FORWARD",
+		"ip6tables -t nat -S",
+		"ip6tables -t nat -D POSTROUTING -j cali-nat-outgoing",
+		"ip6tables -t nat -F cali-nat-outgoing",
+		"ip6tables -t nat -X cali-nat-outgoing",
+		"ip6tables -t mangle -S",
+		"ip6tables -t raw -S",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,155 @@
+package install
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/hostfs"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StateFile records install progress so a failed run can be resumed.
+const StateFile = "/var/lib/go-install-kubernetes/state.json"
+
+type State struct {
+	InputsHash string      `json:"inputsHash"`
+	StartedAt  time.Time   `json:"startedAt"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+	Completed  []StepState `json:"completed"`
+	FailedStep string      `json:"failedStep,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type StepState struct {
+	Name        string    `json:"name"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// inputsHash identifies everything that changes what the steps do: every
+// setting that can come from the config file, and the flags for the node's
+// role, how it joins and where it installs from. Secrets such as the join
+// token and registry passwords are left out, since a hash of a short secret
+// can be guessed back.
+func inputsHash(cfg *config.Config) (string, error) {
+	flags := struct {
+		ControlNode      bool   `yaml:"controlNode"`
+		WorkerNode       bool   `yaml:"workerNode"`
+		SingleNode       bool   `yaml:"singleNode"`
+		OS               string `yaml:"os"`
+		JoinEndpoint     string `yaml:"joinEndpoint"`
+		JoinCAHash       string `yaml:"joinCAHash"`
+		JoinServer       string `yaml:"joinServer"`
+		JoinControlPlane bool   `yaml:"joinControlPlane"`
+		BundlePath       string `yaml:"bundlePath"`
+	}{
+		cfg.IsControlNode, cfg.IsWorkerNode, cfg.IsSingleNode, cfg.OS,
+		cfg.JoinEndpoint, cfg.JoinCAHash, cfg.JoinServer,
+		cfg.JoinControlPlane, cfg.BundlePath,
+	}
+
+	settings := *cfg
+	settings.Registries = slices.Clone(cfg.Registries)
+	for i := range settings.Registries {
+		settings.Registries[i].Password = ""
+	}
+
+	var inputs []byte
+	for _, v := range []any{flags, settings} {
+		content, err := yaml.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, content...)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(inputs)), nil
+}
+
+// loadState returns the state to run with. Without --resume that is always
+// a fresh state; with it, the previous state is reused as long as it was
+// recorded for the same inputs.
+func loadState(cfg *config.Config, fsys hostfs.FS) (*State, error) {
+	hash, err := inputsHash(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fresh := &State{InputsHash: hash, StartedAt: time.Now()}
+
+	if !cfg.Resume {
+		return fresh, nil
+	}
+
+	content, err := fsys.ReadFile(StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("No previous install state found, starting from the beginning")
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", StateFile, err)
+	}
+	if state.InputsHash != hash {
+		return nil, fmt.Errorf("settings have changed since the previous run, rerun without --resume to start over")
+	}
+
+	state.FailedStep = ""
+	state.Error = ""
+	return &state, nil
+}
+
+func (s *State) isCompleted(name string) bool {
+	for _, step := range s.Completed {
+		if step.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *State) complete(name string) {
+	s.Completed = append(s.Completed, StepState{Name: name, CompletedAt: time.Now()})
+}
+
+func (s *State) fail(name string, err error) {
+	s.FailedStep = name
+	s.Error = err.Error()
+}
+
+// save writes the state file. Nothing is recorded for a dry run, since no
+// step really ran.
+func (s *State) save(cfg *config.Config, fsys hostfs.FS) error {
+	if cfg.DryRun {
+		return nil
+	}
+
+	s.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A recorded error can quote a command that carried a secret, so only
+	// root may read the state. The modes are set again for a file left by
+	// an older run.
+	dir := filepath.Dir(StateFile)
+	if err := fsys.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := fsys.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(StateFile, content, 0600); err != nil {
+		return err
+	}
+	return fsys.Chmod(StateFile, 0600)
+}
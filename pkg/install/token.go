@@ -0,0 +1,168 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+	"go-install-kubernetes/pkg/joinserver"
+)
+
+// Token actions
+const (
+	TokenCreate        = "create"
+	TokenList          = "list"
+	TokenRevoke        = "revoke"
+	TokenRevokeExpired = "revoke-expired"
+	TokenUploadCerts   = "upload-certs"
+)
+
+// TokenUsages are what kubeadm lets a bootstrap token be used for.
+var TokenUsages = []string{"signing", "authentication"}
+
+// ValidateTokenUsages checks a comma separated list of token usages.
+func ValidateTokenUsages(usages string) error {
+	for _, usage := range strings.Split(usages, ",") {
+		if !slices.Contains(TokenUsages, usage) {
+			return fmt.Errorf("invalid token usage %q, expected %s", usage, strings.Join(TokenUsages, " or "))
+		}
+	}
+	return nil
+}
+
+// bootstrapToken is one entry of "kubeadm token list -o json".
+type bootstrapToken struct {
+	Token       string     `json:"token"`
+	Description string     `json:"description"`
+	Expires     *time.Time `json:"expires"`
+	Usages      []string   `json:"usages"`
+}
+
+// id is the public half of the token; the secret half is never shown.
+func (t bootstrapToken) id() string {
+	return strings.SplitN(t.Token, ".", 2)[0]
+}
+
+// Token manages the cluster's bootstrap tokens.
+func Token(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	switch cfg.Token.Action {
+	case TokenCreate:
+		return createToken(cfg, r)
+	case TokenList:
+		return listTokens(cfg, r)
+	case TokenRevoke:
+		_, err := r.Run(fmt.Sprintf("kubeadm token delete %s", cfg.Token.ID))
+		if err == nil {
+			fmt.Printf("Revoked token %s\n", cfg.Token.ID)
+		}
+		return err
+	case TokenRevokeExpired:
+		return revokeExpiredTokens(cfg, r)
+	case TokenUploadCerts:
+		key, err := joinserver.UploadCerts(r)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Certificate key (expires in 2 hours): %s\n", key)
+		return nil
+	default:
+		return fmt.Errorf("unknown token action %q", cfg.Token.Action)
+	}
+}
+
+func createToken(cfg *config.Config, r exec.Runner) error {
+	cmd := fmt.Sprintf("kubeadm token create --ttl %s", cfg.Token.TTL)
+	if cfg.Token.Description != "" {
+		cmd += " --description " + exec.Quote(cfg.Token.Description)
+	}
+	if cfg.Token.Usages != "" {
+		cmd += fmt.Sprintf(" --usages %s", cfg.Token.Usages)
+	}
+	if cfg.Token.PrintJoinCommand {
+		cmd += " --print-join-command"
+	}
+
+	res, err := r.Run(cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Print(res.Stdout)
+	return nil
+}
+
+func getTokens(r exec.Runner) ([]bootstrapToken, error) {
+	res, err := r.Run("kubeadm token list -o json")
+	if err != nil {
+		return nil, err
+	}
+
+	// kubeadm prints one JSON object per token rather than a list
+	var tokens []bootstrapToken
+	dec := json.NewDecoder(strings.NewReader(res.Stdout))
+	for {
+		var token bootstrapToken
+		err := dec.Decode(&token)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token list: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func listTokens(cfg *config.Config, r exec.Runner) error {
+	tokens, err := getTokens(r)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEXPIRES\tUSAGES\tDESCRIPTION")
+	now := time.Now()
+	for _, token := range tokens {
+		expires := "never"
+		if token.Expires != nil {
+			expires = token.Expires.Format(time.RFC3339)
+			if token.Expires.Before(now) {
+				expires += " (expired)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", token.id(), expires, strings.Join(token.Usages, ","), token.Description)
+	}
+	return w.Flush()
+}
+
+func revokeExpiredTokens(cfg *config.Config, r exec.Runner) error {
+	tokens, err := getTokens(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	revoked := 0
+	for _, token := range tokens {
+		if token.Expires == nil || token.Expires.After(now) {
+			continue
+		}
+		if _, err := r.Run(fmt.Sprintf("kubeadm token delete %s", token.id())); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked expired token %s\n", token.id())
+		revoked++
+	}
+
+	fmt.Printf("Revoked %d expired token(s)\n", revoked)
+	return nil
+}
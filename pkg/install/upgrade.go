@@ -0,0 +1,306 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/distro"
+	"go-install-kubernetes/pkg/exec"
+	"go-install-kubernetes/pkg/hostfs"
+)
+
+// kubeVersion is a parsed Kubernetes version. The patch is optional so that
+// "1.32" can mean the latest 1.32 release.
+type kubeVersion struct {
+	major, minor, patch int
+	hasPatch            bool
+}
+
+func parseKubeVersion(s string) (kubeVersion, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return kubeVersion{}, fmt.Errorf("invalid Kubernetes version %q", s)
+	}
+
+	var nums []int
+	for _, part := range parts {
+		// Drop any pre-release suffix such as "-rc.1"
+		n, err := strconv.Atoi(strings.SplitN(part, "-", 2)[0])
+		if err != nil {
+			return kubeVersion{}, fmt.Errorf("invalid Kubernetes version %q", s)
+		}
+		nums = append(nums, n)
+	}
+
+	v := kubeVersion{major: nums[0], minor: nums[1]}
+	if len(nums) == 3 {
+		v.patch = nums[2]
+		v.hasPatch = true
+	}
+	return v, nil
+}
+
+// less reports whether v is an older release than o.
+func (v kubeVersion) less(o kubeVersion) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	return v.patch < o.patch
+}
+
+func (v kubeVersion) String() string {
+	if v.hasPatch {
+		return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	}
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// checkUpgradePath allows patch upgrades and upgrades to the next minor
+// release only, which is all kubeadm supports.
+func checkUpgradePath(current, target kubeVersion) error {
+	if target.major != current.major {
+		return fmt.Errorf("cannot upgrade from %s to %s, major version changes are not supported", current, target)
+	}
+	switch {
+	case target.minor < current.minor:
+		return fmt.Errorf("cannot downgrade from %s to %s", current, target)
+	case target.minor > current.minor+1:
+		return fmt.Errorf("cannot upgrade from %s to %s, upgrade one minor version at a time (next is %d.%d)",
+			current, target, current.major, current.minor+1)
+	case target.minor == current.minor && target.hasPatch && target.patch <= current.patch:
+		return fmt.Errorf("already at %s, nothing to upgrade to %s", current, target)
+	}
+	return nil
+}
+
+// upgrade holds what the upgrade steps learn about the node as they go.
+type upgrade struct {
+	distro       distro.Distro
+	target       kubeVersion
+	version      string
+	nodeName     string
+	controlPlane bool
+	canDrain     bool
+}
+
+// Upgrade moves the node to the Kubernetes version in cfg.UpgradeTo,
+// following the kubeadm upgrade procedure for its role.
+func Upgrade(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	target, err := parseKubeVersion(cfg.UpgradeTo)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.Run("kubeadm version -o short")
+	if err != nil {
+		return fmt.Errorf("failed to get current kubeadm version: %v", err)
+	}
+	if current, err := parseKubeVersion(res.Stdout); err == nil {
+		if err := checkUpgradePath(current, target); err != nil {
+			return err
+		}
+		fmt.Printf("Upgrading from %s to %s\n", current, target)
+	} else if !cfg.DryRun {
+		return fmt.Errorf("failed to parse current kubeadm version: %v", err)
+	}
+
+	d, err := detectDistro(cfg, fsys)
+	if err != nil {
+		return err
+	}
+	u := &upgrade{distro: d, target: target}
+
+	u.nodeName, err = nodeName(cfg, r)
+	if err != nil {
+		return err
+	}
+
+	_, err = fsys.ReadFile("/etc/kubernetes/manifests/kube-apiserver.yaml")
+	u.controlPlane = err == nil
+	_, err = fsys.ReadFile("/root/.kube/config")
+	u.canDrain = err == nil
+
+	steps := []struct {
+		name string
+		fn   func(*config.Config, exec.Runner, hostfs.FS) error
+	}{
+		{"Configure Kubernetes repository", func(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+			return d.AddKubernetesRepo(r, fsys, target.String())
+		}},
+		{"Upgrade kubeadm", u.upgradeKubeadm},
+		{"Upgrade node configuration", u.upgradeNode},
+		{"Drain node", u.drainNode},
+		{"Upgrade kubelet and kubectl", u.upgradeKubelet},
+		{"Uncordon node", u.uncordonNode},
+		{"Verify versions", u.verifyVersions},
+	}
+
+	for _, step := range steps {
+		fmt.Printf("Executing: %s...\n", step.name)
+		if err := step.fn(cfg, r, fsys); err != nil {
+			return fmt.Errorf("%s failed: %v", step.name, err)
+		}
+	}
+	return nil
+}
+
+func (u *upgrade) upgradeKubeadm(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if err := u.distro.Unhold(r, "kubeadm"); err != nil {
+		return err
+	}
+	if err := u.distro.Refresh(r); err != nil {
+		return err
+	}
+	if err := u.distro.Install(r, u.distro.Pin("kubeadm", u.target.String())); err != nil {
+		return err
+	}
+	if err := u.distro.Hold(r, "kubeadm"); err != nil {
+		return err
+	}
+
+	// Pin the exact release for the rest of the upgrade
+	res, err := r.Run("kubeadm version -o short")
+	if err != nil {
+		return err
+	}
+	u.version = strings.TrimPrefix(strings.TrimSpace(res.Stdout), "v")
+	if u.version == "" && cfg.DryRun {
+		u.version = u.target.String()
+	}
+	if _, err := parseKubeVersion(u.version); err != nil {
+		return err
+	}
+	return nil
+}
+
+// upgradeNode runs kubeadm upgrade apply on the first control plane node to
+// be upgraded, which moves the cluster to the new version, and kubeadm
+// upgrade node everywhere else.
+func (u *upgrade) upgradeNode(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	first := false
+	if u.controlPlane {
+		var err error
+		if first, err = u.firstControlPlane(cfg, fsys); err != nil {
+			return err
+		}
+	}
+	if !first {
+		_, err := r.Run("kubeadm upgrade node")
+		return err
+	}
+
+	cmds := []string{
+		fmt.Sprintf("kubeadm upgrade plan v%s", u.version),
+		fmt.Sprintf("kubeadm upgrade apply -y v%s", u.version),
+	}
+	for _, cmd := range cmds {
+		if _, err := r.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstControlPlane reports whether the cluster is still at an older version
+// than this upgrade's, so no control plane node has run upgrade apply yet.
+func (u *upgrade) firstControlPlane(cfg *config.Config, fsys hostfs.FS) (bool, error) {
+	client, err := newKubeClient(cfg, fsys, adminKubeconfig)
+	if err != nil {
+		return false, err
+	}
+	current, err := client.ClusterVersion(context.Background())
+	if err != nil {
+		// There is no cluster to ask in a dry run
+		if cfg.DryRun {
+			return true, nil
+		}
+		return false, err
+	}
+	v, err := parseKubeVersion(current)
+	if err != nil {
+		return false, err
+	}
+	target, err := parseKubeVersion(u.version)
+	if err != nil {
+		return false, err
+	}
+	if !v.less(target) {
+		fmt.Printf("The cluster is already at %s, upgrading this control plane node to match\n", v)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (u *upgrade) drainNode(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if !u.canDrain {
+		fmt.Printf("No kubeconfig on this node, drain it from a control plane node with:\n"+
+			"  kubectl drain %s --ignore-daemonsets --delete-emptydir-data\n", u.nodeName)
+		return nil
+	}
+	_, err := r.Run(fmt.Sprintf("kubectl drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%s", u.nodeName, cfg.KubectlTimeout))
+	return err
+}
+
+func (u *upgrade) upgradeKubelet(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if err := u.distro.Unhold(r, "kubelet", "kubectl"); err != nil {
+		return err
+	}
+	if err := u.distro.Install(r, u.distro.Pin("kubelet", u.version), u.distro.Pin("kubectl", u.version)); err != nil {
+		return err
+	}
+	if err := u.distro.Hold(r, "kubelet", "kubectl"); err != nil {
+		return err
+	}
+
+	cmds := []string{
+		"systemctl daemon-reload",
+		"systemctl restart kubelet",
+	}
+	for _, cmd := range cmds {
+		if _, err := r.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *upgrade) uncordonNode(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	if !u.canDrain {
+		fmt.Printf("Uncordon the node from a control plane node with:\n  kubectl uncordon %s\n", u.nodeName)
+		return nil
+	}
+	_, err := r.Run(fmt.Sprintf("kubectl uncordon %s", u.nodeName))
+	return err
+}
+
+func (u *upgrade) verifyVersions(cfg *config.Config, r exec.Runner, fsys hostfs.FS) error {
+	checks := []struct {
+		cmd  string
+		name string
+	}{
+		{"kubeadm version -o short", "kubeadm"},
+		{"kubelet --version", "kubelet"},
+	}
+	for _, check := range checks {
+		res, err := r.Run(check.cmd)
+		if err != nil {
+			return err
+		}
+		if !cfg.DryRun && !strings.Contains(res.Stdout, "v"+u.version) {
+			return fmt.Errorf("%s version mismatch, expected v%s but got %s", check.name, u.version, strings.TrimSpace(res.Stdout))
+		}
+	}
+
+	// Only the control plane has an API server to ask
+	if u.controlPlane {
+		cfg.KubeVersion = u.version
+		return testKubernetesVersion(cfg, r, fsys)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package joinserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+)
+
+// Fetch asks the join server at url for join details. The server's
+// certificate isn't checked; the reply is sealed with the pre-shared key
+// instead, which proves where it came from and keeps the token from anyone
+// in between. Set controlPlane to also get a certificate key for joining as a
+// control plane node. A nil client uses a default one.
+func Fetch(url, psk string, controlPlane bool, client *http.Client) (Info, error) {
+	if client == nil {
+		client = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return Info{}, err
+	}
+	nonce := hex.EncodeToString(raw)
+	now := time.Now().Unix()
+
+	body, err := json.Marshal(request{
+		Nonce:        nonce,
+		Time:         now,
+		ControlPlane: controlPlane,
+		MAC:          requestMAC(psk, nonce, now, controlPlane),
+	})
+	if err != nil {
+		return Info{}, err
+	}
+
+	resp, err := client.Post(strings.TrimSuffix(url, "/")+"/join", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to reach join server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("join server refused the request: %s", resp.Status)
+	}
+
+	var reply response
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return Info{}, fmt.Errorf("failed to decode join server reply: %v", err)
+	}
+	plaintext, err := unseal(psk, nonce, reply.Sealed)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(plaintext, &info); err != nil {
+		return Info{}, fmt.Errorf("failed to decode join details: %v", err)
+	}
+	if err := config.ValidateJoinDetails(info.Endpoint, info.Token, info.CAHash); err != nil {
+		return Info{}, fmt.Errorf("join server sent bad details: %v", err)
+	}
+	if controlPlane {
+		if err := config.ValidateCertificateKey(info.CertificateKey); err != nil {
+			return Info{}, fmt.Errorf("join server sent bad details: %v", err)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,72 @@
+package joinserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// requestMAC proves the caller knows the pre-shared key without sending it.
+// Whether a control plane join is wanted is covered too, so it can't be
+// switched on in transit.
+func requestMAC(psk, nonce string, unixTime int64, controlPlane bool) string {
+	mac := hmac.New(sha256.New, []byte(psk))
+	fmt.Fprintf(mac, "join-request\n%s\n%d\n%t", nonce, unixTime, controlPlane)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sealKey(psk string) []byte {
+	key := sha256.Sum256([]byte("go-install-kubernetes join\n" + psk))
+	return key[:]
+}
+
+// seal encrypts plaintext with a key derived from the pre-shared key. The
+// request nonce is bound in as additional data so a reply can't be replayed
+// against a different request.
+func seal(psk, nonce string, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(sealKey(psk))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(iv, iv, plaintext, []byte(nonce))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func unseal(psk, nonce, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sealKey(psk))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed reply is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(nonce))
+	if err != nil {
+		return nil, fmt.Errorf("reply could not be authenticated, check the pre-shared key")
+	}
+	return plaintext, nil
+}
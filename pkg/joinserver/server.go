@@ -0,0 +1,241 @@
+package joinserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-install-kubernetes/pkg/config"
+	"go-install-kubernetes/pkg/exec"
+)
+
+// How far a request's timestamp may drift from the server clock. Nonces are
+// remembered for this long so a captured request can't be replayed.
+const maxSkew = 5 * time.Minute
+
+// tokenDescription marks the tokens handed out, for kubeadm token list.
+const tokenDescription = "Issued by go-install-kubernetes serve-join"
+
+// Info is what a worker needs to run kubeadm join.
+type Info struct {
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+	CAHash   string `json:"caCertHash"`
+
+	// CertificateKey is only set for control plane joins.
+	CertificateKey string `json:"certificateKey,omitempty"`
+}
+
+type request struct {
+	Nonce        string `json:"nonce"`
+	Time         int64  `json:"time"`
+	ControlPlane bool   `json:"controlPlane"`
+	MAC          string `json:"mac"`
+}
+
+type response struct {
+	Sealed string `json:"sealed"`
+}
+
+// Handler hands out short lived join details to callers that prove they
+// know the pre-shared key.
+type Handler struct {
+	psk          string
+	runner       exec.Runner
+	ttl          string
+	controlPlane bool
+	now          func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewHandler returns a Handler that creates bootstrap tokens with the given
+// TTL through r. Control plane joins, which also hand out the key to the
+// cluster certificates, are refused unless controlPlane is set.
+func NewHandler(psk string, r exec.Runner, ttl string, controlPlane bool) *Handler {
+	return &Handler{
+		psk:          psk,
+		runner:       r,
+		ttl:          ttl,
+		controlPlane: controlPlane,
+		now:          time.Now,
+		seen:         map[string]time.Time{},
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body request
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 4096)).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !h.authorized(body) {
+		fmt.Printf("Refused join request from %s\n", req.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if body.ControlPlane && !h.controlPlane {
+		fmt.Printf("Refused control plane join request from %s\n", req.RemoteAddr)
+		http.Error(w, "control plane joins are not allowed", http.StatusForbidden)
+		return
+	}
+
+	info, err := h.createJoinInfo()
+	if err != nil {
+		fmt.Printf("Failed to create join token: %v\n", err)
+		http.Error(w, "failed to create join token", http.StatusInternalServerError)
+		return
+	}
+	if body.ControlPlane {
+		info.CertificateKey, err = UploadCerts(h.runner)
+		if err != nil {
+			fmt.Printf("Failed to upload certificates: %v\n", err)
+			http.Error(w, "failed to upload certificates", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	plaintext, err := json.Marshal(info)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := seal(h.psk, body.Nonce, plaintext)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("Issued join token to %s\n", req.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Sealed: sealed})
+}
+
+// authorized checks the MAC, the clock skew and that the nonce is new.
+func (h *Handler) authorized(body request) bool {
+	if body.Nonce == "" || !hmac.Equal([]byte(body.MAC), []byte(requestMAC(h.psk, body.Nonce, body.Time, body.ControlPlane))) {
+		return false
+	}
+
+	now := h.now()
+	sent := time.Unix(body.Time, 0)
+	if sent.Before(now.Add(-maxSkew)) || sent.After(now.Add(maxSkew)) {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for nonce, at := range h.seen {
+		if now.Sub(at) > 2*maxSkew {
+			delete(h.seen, nonce)
+		}
+	}
+	if _, ok := h.seen[body.Nonce]; ok {
+		return false
+	}
+	h.seen[body.Nonce] = now
+	return true
+}
+
+func (h *Handler) createJoinInfo() (Info, error) {
+	res, err := h.runner.Run(fmt.Sprintf("kubeadm token create --print-join-command --ttl %s --description %s", h.ttl, exec.Quote(tokenDescription)))
+	if err != nil {
+		return Info{}, err
+	}
+
+	var cfg config.Config
+	if err := config.ParseJoinCommand(res.Stdout, &cfg); err != nil {
+		return Info{}, err
+	}
+	return Info{Endpoint: cfg.JoinEndpoint, Token: cfg.JoinToken, CAHash: cfg.JoinCAHash}, nil
+}
+
+// UploadCerts re-uploads the control plane certificates to the cluster,
+// encrypted with a new key, and returns the key. kubeadm deletes the upload
+// after two hours.
+func UploadCerts(r exec.Runner) (string, error) {
+	res, err := r.Run("kubeadm init phase upload-certs --upload-certs")
+	if err != nil {
+		return "", err
+	}
+
+	// The key is printed on a line of its own after the log lines
+	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
+	key := strings.TrimSpace(lines[len(lines)-1])
+	if err := config.ValidateCertificateKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Serve runs the join server until it fails. It uses a throwaway
+// self-signed certificate; clients don't need to trust it because the
+// replies are sealed with the pre-shared key.
+func Serve(cfg *config.Config, r exec.Runner) error {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/join", NewHandler(cfg.JoinPSK, r, cfg.ServeTokenTTL, cfg.ServeControlPlane))
+
+	srv := &http.Server{
+		Addr:              cfg.ServeListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	fmt.Printf("Serving join details on %s, tokens expire after %s\n", cfg.ServeListen, cfg.ServeTokenTTL)
+	if cfg.ServeControlPlane {
+		fmt.Println("Control plane joins are allowed")
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "go-install-kubernetes serve-join"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
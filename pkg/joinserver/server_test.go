@@ -0,0 +1,161 @@
+package joinserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-install-kubernetes/pkg/exec"
+)
+
+const testPSK = "correct horse battery staple"
+
+var (
+	testCAHash = "sha256:" + strings.Repeat("0f", 32)
+	testKey    = strings.Repeat("ab", 32)
+	testJoin   = "kubeadm join 10.0.0.5:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash " + testCAHash + " \n"
+)
+
+// newTestServer serves a Handler backed by a Recorder that answers the
+// kubeadm commands the way a control plane node would.
+func newTestServer(t *testing.T, controlPlane bool) (*httptest.Server, *exec.Recorder) {
+	r := exec.NewRecorder().
+		On("kubeadm token create", exec.Result{Stdout: testJoin}).
+		On("kubeadm init phase upload-certs", exec.Result{Stdout: "[upload-certs] Using certificate key:\n" + testKey + "\n"})
+	srv := httptest.NewTLSServer(NewHandler(testPSK, r, "1h", controlPlane))
+	t.Cleanup(srv.Close)
+	return srv, r
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	sealed, err := seal(testPSK, "nonce-1", []byte("join details"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unseal(testPSK, "nonce-1", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "join details" {
+		t.Errorf("unsealed %q", got)
+	}
+
+	if _, err := unseal("wrong key", "nonce-1", sealed); err == nil {
+		t.Error("unsealed with the wrong key")
+	}
+	if _, err := unseal(testPSK, "nonce-2", sealed); err == nil {
+		t.Error("unsealed a reply to a different request")
+	}
+	if _, err := unseal(testPSK, "nonce-1", "c2hvcnQ="); err == nil {
+		t.Error("unsealed a truncated reply")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv, r := newTestServer(t, false)
+
+	info, err := Fetch(srv.URL, testPSK, false, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Endpoint: "10.0.0.5:6443", Token: "abcdef.0123456789abcdef", CAHash: testCAHash}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+	if err := r.Expect("kubeadm token create --print-join-command --ttl 1h --description 'Issued by go-install-kubernetes serve-join'"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchControlPlane(t *testing.T) {
+	srv, r := newTestServer(t, true)
+
+	info, err := Fetch(srv.URL, testPSK, true, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CertificateKey != testKey {
+		t.Errorf("got certificate key %q, want %q", info.CertificateKey, testKey)
+	}
+	if err := r.Expect("kubeadm token create", "kubeadm init phase upload-certs --upload-certs"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchRefused(t *testing.T) {
+	tests := []struct {
+		name         string
+		psk          string
+		controlPlane bool
+		status       string
+	}{
+		{"wrong key", "wrong key", false, "401 Unauthorized"},
+		{"control plane not allowed", testPSK, true, "403 Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, r := newTestServer(t, false)
+
+			_, err := Fetch(srv.URL, tt.psk, tt.controlPlane, srv.Client())
+			if err == nil || !strings.Contains(err.Error(), tt.status) {
+				t.Fatalf("expected %s, got %v", tt.status, err)
+			}
+			// No token is created for a refused request
+			if err := r.Expect(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReplayAndSkew(t *testing.T) {
+	h := NewHandler(testPSK, exec.NewRecorder().On("kubeadm token create", exec.Result{Stdout: testJoin}), "1h", false)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	post := func(sent time.Time, nonce string) int {
+		body, _ := json.Marshal(request{
+			Nonce: nonce,
+			Time:  sent.Unix(),
+			MAC:   requestMAC(testPSK, nonce, sent.Unix(), false),
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/join", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	if code := post(now, "nonce-1"); code != http.StatusOK {
+		t.Errorf("first request got %d", code)
+	}
+	if code := post(now, "nonce-1"); code != http.StatusUnauthorized {
+		t.Errorf("replayed request got %d, want 401", code)
+	}
+	if code := post(now.Add(-maxSkew-time.Minute), "nonce-2"); code != http.StatusUnauthorized {
+		t.Errorf("stale request got %d, want 401", code)
+	}
+	if code := post(now.Add(maxSkew+time.Minute), "nonce-3"); code != http.StatusUnauthorized {
+		t.Errorf("request from the future got %d, want 401", code)
+	}
+}
+
+func TestFetchBadDetails(t *testing.T) {
+	// A server that knows the key but sends a token kubeadm would reject
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body request
+		json.NewDecoder(req.Body).Decode(&body)
+		plaintext, _ := json.Marshal(Info{Endpoint: "10.0.0.5:6443", Token: "$(reboot)", CAHash: testCAHash})
+		sealed, _ := seal(testPSK, body.Nonce, plaintext)
+		json.NewEncoder(w).Encode(response{Sealed: sealed})
+	}))
+	defer srv.Close()
+
+	_, err := Fetch(srv.URL, testPSK, false, srv.Client())
+	if err == nil || !strings.Contains(err.Error(), "bad details") {
+		t.Fatalf("expected bad details to be rejected, got %v", err)
+	}
+}
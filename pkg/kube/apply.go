@@ -0,0 +1,139 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// Decode splits a YAML or JSON manifest into its objects, skipping empty
+// documents.
+func Decode(manifest []byte) ([]*unstructured.Unstructured, error) {
+	dec := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	var objs []*unstructured.Unstructured
+	for {
+		var content map[string]interface{}
+		err := dec.Decode(&content)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %v", err)
+		}
+		if len(content) == 0 {
+			continue
+		}
+		objs = append(objs, &unstructured.Unstructured{Object: content})
+	}
+	return objs, nil
+}
+
+// Apply server-side applies every object in a manifest, in order. Applying
+// again is harmless, so a step can be rerun after a failure.
+func (c *Client) Apply(ctx context.Context, manifest []byte) error {
+	objs, err := Decode(manifest)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		if err := c.ApplyObject(ctx, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyObject server-side applies a single object.
+func (c *Client) ApplyObject(ctx context.Context, obj *unstructured.Unstructured) error {
+	if c.skip("apply", describe(obj)) {
+		return nil
+	}
+
+	ri, err := c.resourceFor(obj)
+	if err != nil {
+		return err
+	}
+	_, err = ri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", describe(obj), err)
+	}
+	return nil
+}
+
+// Delete deletes every object in a manifest, in reverse order so that
+// namespaces go last. Objects that are already gone are skipped.
+func (c *Client) Delete(ctx context.Context, manifest []byte) error {
+	objs, err := Decode(manifest)
+	if err != nil {
+		return err
+	}
+	for i := len(objs) - 1; i >= 0; i-- {
+		if err := c.DeleteObject(ctx, objs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteObject deletes a single object and, in the background, anything it
+// owns.
+func (c *Client) DeleteObject(ctx context.Context, obj *unstructured.Unstructured) error {
+	if c.skip("delete", describe(obj)) {
+		return nil
+	}
+
+	ri, err := c.resourceFor(obj)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = ri.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %w", describe(obj), err)
+	}
+	return nil
+}
+
+// resourceFor finds the dynamic client for an object's kind and namespace.
+func (c *Client) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may come from a CRD created since discovery was cached
+		if resettable, ok := c.mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unknown kind for %s: %w", describe(obj), err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.Dynamic.Resource(mapping.Resource), nil
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return c.Dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// describe names an object the way kubectl does in its output.
+func describe(obj *unstructured.Unstructured) string {
+	name := obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return obj.GetKind() + " " + name
+}
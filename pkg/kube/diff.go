@@ -0,0 +1,94 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Diff prints a unified diff, like kubectl diff, between each live object in
+// a manifest and what applying the manifest would make of it. The server
+// works out the result with a dry-run apply, so defaulted fields and fields
+// owned by other managers don't show up as drift. It reports whether any
+// object differs.
+func (c *Client) Diff(ctx context.Context, manifest []byte, w io.Writer) (bool, error) {
+	objs, err := Decode(manifest)
+	if err != nil {
+		return false, err
+	}
+
+	drifted := false
+	for _, obj := range objs {
+		changed, err := c.diffObject(ctx, obj, w)
+		if err != nil {
+			return drifted, err
+		}
+		drifted = drifted || changed
+	}
+	return drifted, nil
+}
+
+func (c *Client) diffObject(ctx context.Context, obj *unstructured.Unstructured, w io.Writer) (bool, error) {
+	ri, err := c.resourceFor(obj)
+	if err != nil {
+		return false, err
+	}
+
+	var from []byte
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		// Missing objects diff against nothing, so the whole object is added
+	case err != nil:
+		return false, fmt.Errorf("failed to get %s: %w", describe(obj), err)
+	default:
+		if from, err = comparable(live); err != nil {
+			return false, err
+		}
+	}
+
+	merged, err := ri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+		DryRun:       []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to dry-run apply %s: %w", describe(obj), err)
+	}
+	to, err := comparable(merged)
+	if err != nil {
+		return false, err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(from)),
+		B:        difflib.SplitLines(string(to)),
+		FromFile: "live/" + describe(obj),
+		ToFile:   "manifest/" + describe(obj),
+		Context:  3,
+	})
+	if err != nil {
+		return false, err
+	}
+	if diff == "" {
+		return false, nil
+	}
+	fmt.Fprint(w, diff)
+	return true, nil
+}
+
+// comparable renders an object as YAML without the bookkeeping fields that
+// change on every write.
+func comparable(obj *unstructured.Unstructured) ([]byte, error) {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "generation")
+	return yaml.Marshal(obj.Object)
+}
@@ -0,0 +1,76 @@
+// Package kube talks to the cluster through client-go, for the steps that
+// run once the control plane is up.
+package kube
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// FieldManager owns the fields this tool sets with server-side apply.
+const FieldManager = "go-install-kubernetes"
+
+// Client bundles the typed and dynamic clients for one cluster.
+type Client struct {
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	mapper    meta.RESTMapper
+	dryRun    io.Writer
+}
+
+// New returns a Client using the given clients, such as fakes in tests. The
+// mapper resolves the kinds found in manifests to API resources.
+func New(clientset kubernetes.Interface, dyn dynamic.Interface, mapper meta.RESTMapper) *Client {
+	return &Client{Clientset: clientset, Dynamic: dyn, mapper: mapper}
+}
+
+// NewFromKubeconfig connects with the credentials in a kubeconfig file.
+func NewFromKubeconfig(path string) (*Client, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %v", path, err)
+	}
+	restConfig.Timeout = 30 * time.Second
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Discovery is deferred and cached, and reset when a manifest refers to
+	// a kind from a CRD that was only just created
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	return New(clientset, dyn, mapper), nil
+}
+
+// NewDryRun returns a Client that prints the changes it would make to w.
+// Reads go to an empty fake cluster.
+func NewDryRun(w io.Writer) *Client {
+	c := New(fake.NewSimpleClientset(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), meta.NewDefaultRESTMapper(nil))
+	c.dryRun = w
+	return c
+}
+
+// skip reports whether a change should only be printed, printing it if so.
+func (c *Client) skip(action, what string) bool {
+	if c.dryRun == nil {
+		return false
+	}
+	fmt.Fprintf(c.dryRun, "  %-6s %s\n", action+":", what)
+	return true
+}
@@ -0,0 +1,259 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testManifest = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: demo
+---
+# an empty document
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: demo
+data:
+  level: debug
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: defaults
+`
+
+func TestDecode(t *testing.T) {
+	objs, err := Decode([]byte(testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, obj := range objs {
+		got = append(got, describe(obj))
+	}
+	want := []string{"Namespace demo", "ConfigMap demo/settings", "ConfigMap defaults"}
+	if !slices.Equal(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+
+	if _, err := Decode([]byte("kind: [")); err == nil {
+		t.Error("decoded a broken manifest")
+	}
+}
+
+func TestApplyAndDelete(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	// The fake tracker can't apply to objects that don't exist yet, so
+	// record the applies instead
+	var actions []string
+	dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		actions = append(actions, "apply "+patch.GetResource().Resource+" "+patch.GetNamespace()+"/"+patch.GetName())
+		return true, obj, nil
+	})
+	dyn.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		del := action.(k8stesting.DeleteAction)
+		actions = append(actions, "delete "+del.GetResource().Resource+" "+del.GetNamespace()+"/"+del.GetName())
+		return false, nil, nil
+	})
+	c := New(fake.NewSimpleClientset(), dyn, mapper)
+
+	if err := c.Apply(context.Background(), []byte(testManifest)); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting objects that are already gone succeeds
+	if err := c.Delete(context.Background(), []byte(testManifest)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"apply namespaces /demo",
+		"apply configmaps demo/settings",
+		"apply configmaps default/defaults",
+		"delete configmaps default/defaults",
+		"delete configmaps demo/settings",
+		"delete namespaces /demo",
+	}
+	if !slices.Equal(actions, want) {
+		t.Errorf("got %v, want %v", actions, want)
+	}
+
+	err := c.Apply(context.Background(), []byte("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown kind for Widget w") {
+		t.Errorf("expected an unknown kind error, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	c := NewDryRun(&out)
+	ctx := context.Background()
+
+	if err := c.Apply(ctx, []byte(testManifest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveTaint(ctx, "node-role.kubernetes.io/control-plane", corev1.TaintEffectNoSchedule); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RunPod(ctx, "default", "nginx", "nginx:1.27"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeletePod(ctx, "default", "nginx"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `  apply: Namespace demo
+  apply: ConfigMap demo/settings
+  apply: ConfigMap defaults
+  taint: remove node-role.kubernetes.io/control-plane:NoSchedule from all nodes
+  create: Pod default/nginx (nginx:1.27)
+  delete: Pod default/nginx
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func node(name string, unschedulable bool, taints ...corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+	}
+}
+
+var (
+	controlPlaneTaint = corev1.Taint{Key: "node-role.kubernetes.io/control-plane", Effect: corev1.TaintEffectNoSchedule}
+	gpuTaint          = corev1.Taint{Key: "gpu", Effect: corev1.TaintEffectNoSchedule}
+)
+
+func TestRemoveTaint(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		node("cp1", false, controlPlaneTaint, gpuTaint),
+		node("cp2", false, corev1.Taint{Key: controlPlaneTaint.Key, Effect: corev1.TaintEffectNoExecute}),
+	)
+	c := New(clientset, nil, nil)
+
+	if err := c.RemoveTaint(context.Background(), controlPlaneTaint.Key, controlPlaneTaint.Effect); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]corev1.Taint{
+		"cp1": {gpuTaint},
+		// Only the matching effect goes
+		"cp2": {{Key: controlPlaneTaint.Key, Effect: corev1.TaintEffectNoExecute}},
+	}
+	for name, taints := range want {
+		n, err := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(n.Spec.Taints, taints) {
+			t.Errorf("node %s has taints %v, want %v", name, n.Spec.Taints, taints)
+		}
+	}
+}
+
+func TestHasSchedulableNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []runtime.Object
+		want  bool
+	}{
+		{"no nodes", nil, false},
+		{"tainted control plane", []runtime.Object{node("cp1", false, controlPlaneTaint)}, false},
+		{"cordoned worker", []runtime.Object{node("cp1", false, controlPlaneTaint), node("w1", true)}, false},
+		{"NoExecute taint", []runtime.Object{node("w1", false, corev1.Taint{Key: "k", Effect: corev1.TaintEffectNoExecute})}, false},
+		{"PreferNoSchedule taint", []runtime.Object{node("w1", false, corev1.Taint{Key: "k", Effect: corev1.TaintEffectPreferNoSchedule})}, true},
+		{"worker", []runtime.Object{node("cp1", false, controlPlaneTaint), node("w1", false)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(fake.NewSimpleClientset(tt.nodes...), nil, nil).HasSchedulableNode(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterVersion(t *testing.T) {
+	kubeadmConfig := func(clusterConfig string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kubeadm-config"},
+			Data:       map[string]string{"ClusterConfiguration": clusterConfig},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    string
+		err     string
+	}{
+		{"set", []runtime.Object{kubeadmConfig("apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\nkubernetesVersion: v1.30.4\n")}, "v1.30.4", ""},
+		{"missing ConfigMap", nil, "", "failed to get kubeadm-config"},
+		{"no version", []runtime.Object{kubeadmConfig("kind: ClusterConfiguration\n")}, "", "has no kubernetesVersion"},
+		{"malformed", []runtime.Object{kubeadmConfig("kubernetesVersion: [")}, "", "failed to parse ClusterConfiguration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(fake.NewSimpleClientset(tt.objects...), nil, nil).ClusterVersion(context.Background())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.31.5"}
+
+	got, err := New(clientset, nil, nil).ServerVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "v1.31.5" {
+		t.Errorf("got %s, want v1.31.5", got)
+	}
+}
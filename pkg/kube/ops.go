@@ -0,0 +1,158 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ServerVersion returns the API server's version, such as "v1.31.5".
+func (c *Client) ServerVersion() (string, error) {
+	info, err := c.Clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %w", err)
+	}
+	return info.GitVersion, nil
+}
+
+// ClusterVersion returns the Kubernetes version kubeadm last set up the
+// control plane for, from the ClusterConfiguration in the kubeadm-config
+// ConfigMap. It lags the API servers during an upgrade until the first
+// control plane node has run kubeadm upgrade apply.
+func (c *Client) ClusterVersion(ctx context.Context) (string, error) {
+	cm, err := c.Clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "kubeadm-config", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get kubeadm-config: %w", err)
+	}
+	var clusterConfig struct {
+		KubernetesVersion string `json:"kubernetesVersion"`
+	}
+	if err := yaml.Unmarshal([]byte(cm.Data["ClusterConfiguration"]), &clusterConfig); err != nil {
+		return "", fmt.Errorf("failed to parse ClusterConfiguration: %w", err)
+	}
+	if clusterConfig.KubernetesVersion == "" {
+		return "", fmt.Errorf("ClusterConfiguration has no kubernetesVersion")
+	}
+	return clusterConfig.KubernetesVersion, nil
+}
+
+// RemoveTaint removes a taint from every node that has it.
+func (c *Client) RemoveTaint(ctx context.Context, key string, effect corev1.TaintEffect) error {
+	if c.skip("taint", fmt.Sprintf("remove %s:%s from all nodes", key, effect)) {
+		return nil
+	}
+
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		var kept []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != key || taint.Effect != effect {
+				kept = append(kept, taint)
+			}
+		}
+		if len(kept) == len(node.Spec.Taints) {
+			continue
+		}
+
+		node.Spec.Taints = kept
+		if _, err := c.Clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+			return fmt.Errorf("failed to untaint node %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// HasSchedulableNode reports whether any node accepts ordinary pods: it
+// isn't cordoned and has no NoSchedule or NoExecute taint.
+func (c *Client) HasSchedulableNode(ctx context.Context) (bool, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list nodes: %w", err)
+	}
+nodes:
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		for _, taint := range node.Spec.Taints {
+			if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+				continue nodes
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// RunPod starts a single container pod labelled run=<name>, like kubectl run.
+func (c *Client) RunPod(ctx context.Context, namespace, name, image string) error {
+	return c.CreatePod(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": name},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: name, Image: image}},
+		},
+	})
+}
+
+// CreatePod creates a pod.
+func (c *Client) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	if c.skip("create", fmt.Sprintf("Pod %s/%s (%s)", pod.Namespace, pod.Name, pod.Spec.Containers[0].Image)) {
+		return nil
+	}
+
+	if _, err := c.Clientset.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to create pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// CreatePVC creates a PersistentVolumeClaim.
+func (c *Client) CreatePVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	if c.skip("create", fmt.Sprintf("PersistentVolumeClaim %s/%s", pvc.Namespace, pvc.Name)) {
+		return nil
+	}
+
+	if _, err := c.Clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, pvc, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to create pvc %s/%s: %w", pvc.Namespace, pvc.Name, err)
+	}
+	return nil
+}
+
+// DeletePVC deletes a PersistentVolumeClaim, succeeding if it is already
+// gone.
+func (c *Client) DeletePVC(ctx context.Context, namespace, name string) error {
+	if c.skip("delete", fmt.Sprintf("PersistentVolumeClaim %s/%s", namespace, name)) {
+		return nil
+	}
+
+	err := c.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pvc %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// DeletePod deletes a pod, succeeding if it is already gone.
+func (c *Client) DeletePod(ctx context.Context, namespace, name string) error {
+	if c.skip("delete", fmt.Sprintf("Pod %s/%s", namespace, name)) {
+		return nil
+	}
+
+	err := c.Clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pod %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go-install-kubernetes/pkg/kube"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// API errors such as NotFound, while an operator has yet to create an
+// object, or connection refused, while the API server restarts, are
+// returned as blocking rather than as errors so that the wait goes on.
+
+// Transient reports whether an error from the API server may go away on its
+// own: a connection error while it restarts, or a response asking the
+// client to come back later. Others, such as Forbidden, won't, so waiting
+// for an object to go should stop at them.
+func Transient(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return true
+	}
+	return apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
+
+// ConditionTrue waits for a status condition on any kind of object, such as
+// an operator's custom resource. Leave namespace empty for cluster scoped
+// objects.
+func ConditionTrue(c *kube.Client, resource schema.GroupVersionResource, namespace, name, condType string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		id := resource.Resource + " " + name
+		if namespace != "" {
+			id = resource.Resource + " " + namespace + "/" + name
+		}
+
+		obj, err := c.Dynamic.Resource(resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("%s (%v)", id, err), nil
+		}
+
+		conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		if err != nil {
+			return false, "", fmt.Errorf("%s has malformed conditions: %v", id, err)
+		}
+		for _, raw := range conditions {
+			cond, ok := raw.(map[string]interface{})
+			if ok && cond["type"] == condType && cond["status"] == "True" {
+				return true, "", nil
+			}
+		}
+		return false, fmt.Sprintf("%s (%s not True)", id, condType), nil
+	}
+}
+
+// CRDEstablished waits for a CustomResourceDefinition to be served.
+func CRDEstablished(c *kube.Client, name string) Condition {
+	return ConditionTrue(c, crdResource, "", name, "Established")
+}
+
+// DeploymentAvailable waits for a Deployment to finish rolling out with all
+// of its replicas available.
+func DeploymentAvailable(c *kube.Client, namespace, name string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		id := fmt.Sprintf("deployment %s/%s", namespace, name)
+		d, err := c.Clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("%s (%v)", id, err), nil
+		}
+
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		switch {
+		case d.Status.ObservedGeneration < d.Generation:
+			return false, id + " (update not observed yet)", nil
+		case d.Status.UpdatedReplicas < replicas || d.Status.AvailableReplicas < replicas:
+			return false, fmt.Sprintf("%s (%d/%d available)", id, d.Status.AvailableReplicas, replicas), nil
+		case !deploymentAvailable(d):
+			return false, id + " (not Available)", nil
+		}
+		return true, "", nil
+	}
+}
+
+func deploymentAvailable(d *appsv1.Deployment) bool {
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// DaemonSetRolledOut waits for a DaemonSet to be updated and available on
+// every node it should run on.
+func DaemonSetRolledOut(c *kube.Client, namespace, name string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		id := fmt.Sprintf("daemonset %s/%s", namespace, name)
+		ds, err := c.Clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("%s (%v)", id, err), nil
+		}
+
+		desired := ds.Status.DesiredNumberScheduled
+		switch {
+		case ds.Status.ObservedGeneration < ds.Generation:
+			return false, id + " (update not observed yet)", nil
+		case ds.Status.UpdatedNumberScheduled < desired || ds.Status.NumberAvailable < desired:
+			return false, fmt.Sprintf("%s (%d/%d available)", id, ds.Status.NumberAvailable, desired), nil
+		}
+		return true, "", nil
+	}
+}
+
+// PodsReady waits for every pod in the namespace (all namespaces if empty)
+// matching the label selector (all pods if empty) to be Ready. Pods that
+// ran to completion, such as job pods, count as done.
+func PodsReady(c *kube.Client, namespace, selector string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		pods, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, fmt.Sprintf("pods (%v)", err), nil
+		}
+		if len(pods.Items) == 0 {
+			return false, "no pods yet", nil
+		}
+
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if ready, reason := podReady(pod); !ready {
+				return false, fmt.Sprintf("pod %s/%s (%s)", pod.Namespace, pod.Name, reason), nil
+			}
+		}
+		return true, "", nil
+	}
+}
+
+// PodSucceeded waits for a pod to run to completion. A pod that failed
+// won't recover, so that is an error rather than something to wait on.
+func PodSucceeded(c *kube.Client, namespace, name string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		id := fmt.Sprintf("pod %s/%s", namespace, name)
+		pod, err := c.Clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("%s (%v)", id, err), nil
+		}
+
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			return true, "", nil
+		case corev1.PodFailed:
+			return false, "", fmt.Errorf("%s failed: %s", id, pod.Status.Message)
+		}
+		_, reason := podReady(pod)
+		return false, fmt.Sprintf("%s (%s)", id, reason), nil
+	}
+}
+
+// PVCBound waits for a PersistentVolumeClaim to be bound to a volume.
+func PVCBound(c *kube.Client, namespace, name string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		id := fmt.Sprintf("pvc %s/%s", namespace, name)
+		pvc, err := c.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("%s (%v)", id, err), nil
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return false, fmt.Sprintf("%s (%s)", id, pvc.Status.Phase), nil
+		}
+		return true, "", nil
+	}
+}
+
+// podReady judges a single pod, returning why it isn't ready. A waiting
+// container's reason, like CrashLoopBackOff, says more than the phase.
+func podReady(pod *corev1.Pod) (bool, string) {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return true, ""
+	case corev1.PodRunning:
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return true, ""
+			}
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return false, status.State.Waiting.Reason
+		}
+	}
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+		return false, "not Ready"
+	case "":
+		// Not yet seen by the scheduler
+		return false, string(corev1.PodPending)
+	}
+	return false, string(pod.Status.Phase)
+}
+
+// NodeRegistered waits for a node to exist, which is all a freshly joined
+// node's own credentials can check.
+func NodeRegistered(c *kube.Client, name string) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		if _, err := c.Clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{}); err != nil {
+			return false, fmt.Sprintf("node %s (%v)", name, err), nil
+		}
+		return true, "", nil
+	}
+}
+
+// NodesReady waits for every node to be Ready.
+func NodesReady(c *kube.Client) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("nodes (%v)", err), nil
+		}
+		if len(nodes.Items) == 0 {
+			return false, "no nodes yet", nil
+		}
+
+	nodes:
+		for _, node := range nodes.Items {
+			for _, cond := range node.Status.Conditions {
+				if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+					continue nodes
+				}
+			}
+			return false, fmt.Sprintf("node %s (not Ready)", node.Name), nil
+		}
+		return true, "", nil
+	}
+}
+
+// NoTaint waits for no node to carry a taint with the key and effect.
+func NoTaint(c *kube.Client, key string, effect corev1.TaintEffect) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, fmt.Sprintf("nodes (%v)", err), nil
+		}
+
+		for _, node := range nodes.Items {
+			for _, taint := range node.Spec.Taints {
+				if taint.Key == key && taint.Effect == effect {
+					return false, fmt.Sprintf("node %s (still tainted %s:%s)", node.Name, key, effect), nil
+				}
+			}
+		}
+		return true, "", nil
+	}
+}
@@ -0,0 +1,193 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-install-kubernetes/pkg/kube"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newClient(objects ...runtime.Object) *kube.Client {
+	var typed, dynamic []runtime.Object
+	for _, obj := range objects {
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			dynamic = append(dynamic, obj)
+		} else {
+			typed = append(typed, obj)
+		}
+	}
+	return kube.New(fake.NewSimpleClientset(typed...), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), dynamic...), meta.NewDefaultRESTMapper(nil))
+}
+
+// check runs a condition once and compares what it reports.
+func check(t *testing.T, cond Condition, wantDone bool, wantBlocking string) {
+	t.Helper()
+	done, blocking, err := cond(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != wantDone || !strings.Contains(blocking, wantBlocking) {
+		t.Errorf("got %v, %q, want %v, %q", done, blocking, wantDone, wantBlocking)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	resource := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("dial tcp 10.0.0.5:6443: connect: connection refused"), true},
+		{apierrors.NewServiceUnavailable("restarting"), true},
+		{apierrors.NewTooManyRequests("slow down", 1), true},
+		{apierrors.NewInternalError(errors.New("etcd")), true},
+		{apierrors.NewTimeoutError("etcd", 1), true},
+		{apierrors.NewForbidden(resource, "nginx", errors.New("rbac")), false},
+		{apierrors.NewUnauthorized("expired"), false},
+		{apierrors.NewBadRequest("bad"), false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("Transient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDeploymentAvailable(t *testing.T) {
+	deployment := func(generation, observed int64, available int32, cond corev1.ConditionStatus) *appsv1.Deployment {
+		replicas := int32(2)
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns", Generation: generation},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: observed,
+				UpdatedReplicas:    2,
+				AvailableReplicas:  available,
+				Conditions:         []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: cond}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		done     bool
+		blocking string
+	}{
+		{"missing", nil, false, "deployment kube-system/coredns (deployments.apps \"coredns\" not found)"},
+		{"not observed", []runtime.Object{deployment(2, 1, 2, corev1.ConditionTrue)}, false, "(update not observed yet)"},
+		{"rolling out", []runtime.Object{deployment(1, 1, 1, corev1.ConditionTrue)}, false, "(1/2 available)"},
+		{"not available", []runtime.Object{deployment(1, 1, 2, corev1.ConditionFalse)}, false, "(not Available)"},
+		{"available", []runtime.Object{deployment(1, 1, 2, corev1.ConditionTrue)}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check(t, DeploymentAvailable(newClient(tt.objects...), "kube-system", "coredns"), tt.done, tt.blocking)
+		})
+	}
+}
+
+func TestDaemonSetRolledOut(t *testing.T) {
+	daemonSet := func(updated, available int32) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-proxy"},
+			Status: appsv1.DaemonSetStatus{
+				DesiredNumberScheduled: 3,
+				UpdatedNumberScheduled: updated,
+				NumberAvailable:        available,
+			},
+		}
+	}
+
+	check(t, DaemonSetRolledOut(newClient(daemonSet(3, 2)), "kube-system", "kube-proxy"), false, "daemonset kube-system/kube-proxy (2/3 available)")
+	check(t, DaemonSetRolledOut(newClient(daemonSet(2, 3)), "kube-system", "kube-proxy"), false, "(3/3 available)")
+	check(t, DaemonSetRolledOut(newClient(daemonSet(3, 3)), "kube-system", "kube-proxy"), true, "")
+}
+
+func TestPodsReady(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase, ready bool) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": name}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if ready {
+			p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		selector string
+		done     bool
+		blocking string
+	}{
+		{"no pods", nil, "", false, "no pods yet"},
+		{"unscheduled", []runtime.Object{pod("web", "", false)}, "", false, "pod default/web (Pending)"},
+		{"not ready", []runtime.Object{pod("web", corev1.PodRunning, false)}, "", false, "pod default/web (not Ready)"},
+		{"completed job", []runtime.Object{pod("web", corev1.PodRunning, true), pod("job", corev1.PodSucceeded, false)}, "", true, ""},
+		{"selector", []runtime.Object{pod("web", corev1.PodRunning, true), pod("db", corev1.PodPending, false)}, "app=web", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check(t, PodsReady(newClient(tt.objects...), "default", tt.selector), tt.done, tt.blocking)
+		})
+	}
+}
+
+func TestPodSucceeded(t *testing.T) {
+	failed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "check"},
+		Status:     corev1.PodStatus{Phase: corev1.PodFailed, Message: "exit code 1"},
+	}
+	_, _, err := PodSucceeded(newClient(failed), "default", "check")(context.Background())
+	if err == nil || err.Error() != "pod default/check failed: exit code 1" {
+		t.Errorf("expected the failure to stop the wait, got %v", err)
+	}
+}
+
+func TestNoTaint(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp1"},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: "node-role.kubernetes.io/control-plane", Effect: corev1.TaintEffectNoSchedule},
+		}},
+	}
+	c := newClient(node)
+	check(t, NoTaint(c, "node-role.kubernetes.io/control-plane", corev1.TaintEffectNoSchedule), false, "node cp1 (still tainted")
+	check(t, NoTaint(c, "node-role.kubernetes.io/control-plane", corev1.TaintEffectNoExecute), true, "")
+}
+
+func TestCRDEstablished(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "installations.operator.tigera.io"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "NamesAccepted", "status": "True"},
+				map[string]interface{}{"type": "Established", "status": "False"},
+			},
+		},
+	}}
+	check(t, CRDEstablished(newClient(crd), "installations.operator.tigera.io"), false, "customresourcedefinitions installations.operator.tigera.io (Established not True)")
+
+	unstructured.SetNestedSlice(crd.Object, []interface{}{
+		map[string]interface{}{"type": "Established", "status": "True"},
+	}, "status", "conditions")
+	check(t, CRDEstablished(newClient(crd), "installations.operator.tigera.io"), true, "")
+}
@@ -0,0 +1,89 @@
+// Package wait polls the cluster until objects are ready, backing off
+// between checks, and says what was still blocking when it gives up.
+package wait
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Condition checks once whether what is being waited for is ready. When it
+// isn't, blocking describes the object holding things up, such as
+// "pod kube-system/coredns-abc (CrashLoopBackOff)". A non-nil error stops
+// the wait at once, so conditions return transient problems as blocking.
+type Condition func(ctx context.Context) (done bool, blocking string, err error)
+
+// Backoff is how long to wait between checks. The delay starts at Initial
+// and is multiplied by Factor after each check, up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+}
+
+// DefaultBackoff suits cluster objects, which take seconds to minutes.
+var DefaultBackoff = Backoff{Initial: time.Second, Max: 15 * time.Second, Factor: 2}
+
+// TimeoutError is returned when the deadline passes before the condition
+// is met.
+type TimeoutError struct {
+	What     string
+	Blocking string
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Blocking == "" {
+		return fmt.Sprintf("timed out waiting for %s", e.What)
+	}
+	return fmt.Sprintf("timed out waiting for %s, still waiting on %s", e.What, e.Blocking)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// For checks cond until it is done, it fails or ctx ends. what names the
+// wait in the error.
+func For(ctx context.Context, what string, b Backoff, cond Condition) error {
+	delay := b.Initial
+	blocking := ""
+	for {
+		done, nowBlocking, err := cond(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for %s: %v", what, err)
+		}
+		if done {
+			return nil
+		}
+		blocking = nowBlocking
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &TimeoutError{What: what, Blocking: blocking, Err: ctx.Err()}
+		case <-timer.C:
+		}
+
+		delay = time.Duration(float64(delay) * b.Factor)
+		if delay > b.Max {
+			delay = b.Max
+		}
+	}
+}
+
+// All is done once every condition is, checking them in order and stopping
+// at the first that isn't.
+func All(conds ...Condition) Condition {
+	return func(ctx context.Context) (bool, string, error) {
+		for _, cond := range conds {
+			done, blocking, err := cond(ctx)
+			if err != nil || !done {
+				return done, blocking, err
+			}
+		}
+		return true, "", nil
+	}
+}
@@ -0,0 +1,78 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Factor: 2}
+
+func TestFor(t *testing.T) {
+	checks := 0
+	err := For(context.Background(), "the thing", testBackoff, func(context.Context) (bool, string, error) {
+		checks++
+		return checks == 3, "not yet", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks != 3 {
+		t.Errorf("checked %d times, want 3", checks)
+	}
+}
+
+func TestForError(t *testing.T) {
+	err := For(context.Background(), "the thing", testBackoff, func(context.Context) (bool, string, error) {
+		return false, "", errors.New("broken")
+	})
+	if err == nil || err.Error() != "waiting for the thing: broken" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestForTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	checks := 0
+	err := For(ctx, "the thing", testBackoff, func(context.Context) (bool, string, error) {
+		checks++
+		return false, fmt.Sprintf("check %d", checks), nil
+	})
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	// The error names what blocked at the last check
+	if want := fmt.Sprintf("check %d", checks); timeout.Blocking != want {
+		t.Errorf("blocking on %q, want %q", timeout.Blocking, want)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%v doesn't wrap the context error", err)
+	}
+	if !strings.HasPrefix(err.Error(), "timed out waiting for the thing, still waiting on check ") {
+		t.Errorf("got %q", err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	var checked []string
+	cond := func(name string, done bool) Condition {
+		return func(context.Context) (bool, string, error) {
+			checked = append(checked, name)
+			return done, name, nil
+		}
+	}
+
+	done, blocking, err := All(cond("a", true), cond("b", false), cond("c", false))(context.Background())
+	if done || blocking != "b" || err != nil {
+		t.Errorf("got %v, %q, %v, want false, \"b\", nil", done, blocking, err)
+	}
+	if strings.Join(checked, ",") != "a,b" {
+		t.Errorf("checked %v, want to stop at b", checked)
+	}
+}